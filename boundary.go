@@ -0,0 +1,86 @@
+package trie
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// WordBoundary is the byte terminating every key of a WordBoundaryTrie. Keys must not contain it,
+// while inputs may contain it to mark the ends of words.
+const WordBoundary byte = 0
+
+// WordBoundaryTrie matches keys as whole words: a key only matches the start of an input if the input ends right
+// after it or continues with WordBoundary, so "ab" matches "ab" and "ab\x00cd" but not "abc". Keys are stored
+// with WordBoundary appended in a Trie.
+type WordBoundaryTrie struct {
+	trie Trie
+}
+
+// NewWordBoundaryTrie creates an empty WordBoundaryTrie.
+func NewWordBoundaryTrie() *WordBoundaryTrie {
+	return &WordBoundaryTrie{}
+}
+
+// Add a key value to WordBoundaryTrie. Override the value if the same key is given again.
+// Return an error without adding the key if it contains WordBoundary.
+func (this *WordBoundaryTrie) Add(key []byte, value Value) error {
+	if bytes.IndexByte(key, WordBoundary) >= 0 {
+		return fmt.Errorf("trie: key %q contains the word boundary %q", key, WordBoundary)
+	}
+	this.trie.Add(terminated(key), value)
+	return nil
+}
+
+// Get the value associated with the key. If no such key was added, return nil, false.
+func (this *WordBoundaryTrie) GetBytes(key []byte) (value Value, found bool) {
+	return this.trie.GetBytes(terminated(key))
+}
+
+// Delete the key and return the value associated with it. If no such key was added, return nil, false.
+func (this *WordBoundaryTrie) Delete(key []byte) (old Value, existed bool) {
+	return this.trie.Delete(terminated(key))
+}
+
+// Len returns the number of keys in the WordBoundaryTrie.
+func (this *WordBoundaryTrie) Len() int {
+	return this.trie.Len()
+}
+
+// Match the shortest key ending at a word boundary of the input and associated value. PrefixLength of the match
+// doesn't include the boundary. If no key is found, return {0, nil}, false.
+func (this *WordBoundaryTrie) MatchShortestPrefixBytes(input []byte) (match PrefixMatch, found bool) {
+	r := this.MatchAllPrefixesBytes(input)
+	if len(r) == 0 {
+		return PrefixMatch{}, false
+	}
+	return r[0], true
+}
+
+// Match the longest key ending at a word boundary of the input and associated value. PrefixLength of the match
+// doesn't include the boundary. If no key is found, return {0, nil}, false.
+func (this *WordBoundaryTrie) MatchLongestPrefixBytes(input []byte) (match PrefixMatch, found bool) {
+	r := this.MatchAllPrefixesBytes(input)
+	if len(r) == 0 {
+		return PrefixMatch{}, false
+	}
+	return r[len(r)-1], true
+}
+
+// Match all keys ending at word boundaries of the input and associated values in increasing length.
+// If no key is found, return an empty list.
+func (this *WordBoundaryTrie) MatchAllPrefixesBytes(input []byte) []PrefixMatch {
+	// The end of the input is a word boundary too.
+	r := this.trie.MatchAllPrefixesBytes(terminated(input))
+	for i := range r {
+		r[i].PrefixLength--
+	}
+	return r
+}
+
+// terminated returns a copy of b followed by WordBoundary.
+func terminated(b []byte) []byte {
+	t := make([]byte, len(b)+1)
+	copy(t, b)
+	t[len(b)] = WordBoundary
+	return t
+}
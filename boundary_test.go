@@ -0,0 +1,67 @@
+package trie
+
+import (
+	"reflect"
+	"testing"
+)
+
+func createTestWordBoundaryTrie() *WordBoundaryTrie {
+	t := NewWordBoundaryTrie()
+	for _, k := range []string{"ab", "abc", "abcd ef"} {
+		t.Add([]byte(k), k)
+	}
+	return t
+}
+
+func TestWordBoundaryTrieAdd(t *testing.T) {
+	trie := createTestWordBoundaryTrie()
+	if err := trie.Add([]byte("a\x00b"), 1); err == nil {
+		t.Errorf("Expected error for a key with the word boundary")
+	}
+	if trie.Len() != 3 {
+		t.Errorf("Wrong length %d, expected 3", trie.Len())
+	}
+	if v, ok := trie.GetBytes([]byte("ab")); !ok || v != "ab" {
+		t.Errorf("Wrong value %v, %v for key ab", v, ok)
+	}
+	if _, ok := trie.GetBytes([]byte("abcd")); ok {
+		t.Errorf("Unexpected key abcd")
+	}
+	if v, ok := trie.Delete([]byte("abc")); !ok || v != "abc" || trie.Len() != 2 {
+		t.Errorf("Wrong deleted value %v, %v", v, ok)
+	}
+}
+
+func TestWordBoundaryTrieMatchPrefix(t *testing.T) {
+	trie := createTestWordBoundaryTrie()
+	plain := NewTrie()
+	plain.AddString("ab", "ab")
+	// A plain Trie matches the stored "ab" inside "abx", a WordBoundaryTrie doesn't.
+	if _, ok := plain.MatchLongestPrefixString("abx"); !ok {
+		t.Errorf("Plain trie should match ab in abx")
+	}
+	if m, ok := trie.MatchLongestPrefixBytes([]byte("abx")); ok {
+		t.Errorf("Unexpected match %v in abx", m)
+	}
+	// "abcd" is only a prefix of the stored "abcd ef", not a key.
+	if m, ok := trie.MatchLongestPrefixBytes([]byte("abcd")); ok {
+		t.Errorf("Unexpected match %v in abcd", m)
+	}
+	cases := map[string][]PrefixMatch{
+		"ab":             {{2, "ab"}},
+		"ab\x00cd":       {{2, "ab"}},
+		"abc\x00":        {{3, "abc"}},
+		"abcd ef":        {{7, "abcd ef"}},
+		"abcd ef\x00abc": {{7, "abcd ef"}},
+		"abcd efg":       {},
+		"a":              {},
+	}
+	for input, expected := range cases {
+		if r := trie.MatchAllPrefixesBytes([]byte(input)); !reflect.DeepEqual(r, expected) {
+			t.Errorf("Wrong matches %v for %q, expected %v", r, input, expected)
+		}
+	}
+	if m, ok := trie.MatchShortestPrefixBytes([]byte("abc")); !ok || m.PrefixLength != 3 {
+		t.Errorf("Wrong shortest match %v, %v", m, ok)
+	}
+}
@@ -0,0 +1,56 @@
+package trie
+
+// Cursor descends a Trie one byte at a time, e.g. while a user types, and tells whether the bytes fed so far
+// form a key. The Trie must not be modified while the Cursor is in use.
+type Cursor struct {
+	root   *Trie
+	node   *Trie // the node reached by the bytes fed so far, or its parent while inside the edge to child
+	child  *Trie // the node whose prefix is being matched, nil at node
+	offset int   // number of bytes of the prefix of child matched so far
+}
+
+// Cursor returns a Cursor positioned at the root of the Trie, before any byte.
+func (this *Trie) Cursor() *Cursor {
+	return &Cursor{root: this, node: this}
+}
+
+// Advance feeds the byte b and reports whether some key continues with the bytes fed so far followed by b.
+// If none does, the Cursor stays where it was.
+func (c *Cursor) Advance(b byte) bool {
+	if c.node == nil {
+		return false
+	}
+	if c.root.foldsCase() {
+		b = lowerASCII(b)
+	}
+	child, offset := c.child, c.offset
+	if child == nil {
+		if child = c.node.children[b]; child == nil {
+			return false
+		}
+		offset = 0
+	}
+	if child.prefix[offset] != b {
+		return false
+	}
+	offset++
+	if offset == len(child.prefix) {
+		c.node, c.child, c.offset = child, nil, 0
+	} else {
+		c.child, c.offset = child, offset
+	}
+	return true
+}
+
+// AtValue returns the value of the key formed by the bytes fed so far. If they aren't a key, return nil, false.
+func (c *Cursor) AtValue() (value Value, found bool) {
+	if c.node == nil || c.child != nil || c.node.value == nil {
+		return nil, false
+	}
+	return *c.node.value, true
+}
+
+// Reset moves the Cursor back to the root, as if no byte was fed.
+func (c *Cursor) Reset() {
+	c.node, c.child, c.offset = c.root, nil, 0
+}
@@ -0,0 +1,68 @@
+package trie
+
+import (
+	"testing"
+)
+
+func TestCursor(t *testing.T) {
+	trie := createTestTrie()
+	cursor := trie.Cursor()
+	if _, ok := cursor.AtValue(); ok {
+		t.Errorf("Unexpected value at the root")
+	}
+	for i, b := range []byte("abcdefg") {
+		if !cursor.Advance(b) {
+			t.Fatalf("Unable to advance by %c", b)
+		}
+		v, ok := cursor.AtValue()
+		if i < 6 && ok {
+			t.Errorf("Unexpected value %v after %s", v, "abcdefg"[:i+1])
+		}
+		if i == 6 && (!ok || v != "abcdefg") {
+			t.Errorf("Wrong value %v, %v after abcdefg", v, ok)
+		}
+	}
+	if cursor.Advance('Y') {
+		t.Errorf("Unexpected path with abcdefgY")
+	}
+	if v, ok := cursor.AtValue(); !ok || v != "abcdefg" {
+		t.Errorf("Failed Advance should not move the cursor, but got %v, %v", v, ok)
+	}
+	for _, b := range []byte("hi") {
+		cursor.Advance(b)
+	}
+	if v, ok := cursor.AtValue(); !ok || v != "abcdefghi" {
+		t.Errorf("Wrong value %v, %v after abcdefghi", v, ok)
+	}
+	cursor.Reset()
+	for _, b := range []byte("abX") {
+		if !cursor.Advance(b) {
+			t.Errorf("Unable to advance by %c after Reset", b)
+		}
+	}
+	if cursor.Advance('c') {
+		t.Errorf("Unexpected path with abXc")
+	}
+}
+
+func TestCursorEmptyTrie(t *testing.T) {
+	var nilTrie *Trie
+	for _, trie := range []*Trie{nilTrie, NewTrie()} {
+		cursor := trie.Cursor()
+		if cursor.Advance('a') {
+			t.Errorf("Unexpected path in empty trie")
+		}
+		if _, ok := cursor.AtValue(); ok {
+			t.Errorf("Unexpected value in empty trie")
+		}
+	}
+	folded := NewTrieCaseInsensitive()
+	folded.AddString("Go", 1)
+	cursor := folded.Cursor()
+	if !cursor.Advance('G') || !cursor.Advance('O') {
+		t.Errorf("Cursor should ignore case")
+	}
+	if v, ok := cursor.AtValue(); !ok || v != 1 {
+		t.Errorf("Wrong value %v, %v", v, ok)
+	}
+}
@@ -0,0 +1,38 @@
+package trie
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// WriteDOT writes the structure of the Trie as a Graphviz directed graph. Each node is labeled with its prefix
+// and nodes holding a value are drawn as double circles. Each edge is labeled with the byte it branches on.
+func (this *Trie) WriteDOT(w io.Writer) error {
+	if this == nil {
+		this = NewTrie()
+	}
+	var buf bytes.Buffer
+	buf.WriteString("digraph trie {\n")
+	id := 0
+	this.writeDOT(&buf, &id)
+	buf.WriteString("}\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// writeDOT writes the node with the next id and its subtree, and returns the id of the node.
+func (this *Trie) writeDOT(buf *bytes.Buffer, id *int) int {
+	n := *id
+	*id++
+	shape := "circle"
+	if this.value != nil {
+		shape = "doublecircle"
+	}
+	fmt.Fprintf(buf, "\tn%d [label=%q, shape=%s];\n", n, this.prefix, shape)
+	for _, child := range this.sortedChildren() {
+		c := child.writeDOT(buf, id)
+		fmt.Fprintf(buf, "\tn%d -> n%d [label=%q];\n", n, c, child.prefix[:1])
+	}
+	return n
+}
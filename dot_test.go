@@ -0,0 +1,62 @@
+package trie
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTrieWriteDOT(t *testing.T) {
+	trie := NewTrie()
+	trie.Add([]byte("ab"), 1)
+	trie.Add([]byte("abc"), 2)
+	trie.Add([]byte("ad"), 3)
+	var buf bytes.Buffer
+	if err := trie.WriteDOT(&buf); err != nil {
+		t.Fatalf("Unable to write DOT: %v", err)
+	}
+	expected := `digraph trie {
+	n0 [label="", shape=circle];
+	n1 [label="a", shape=circle];
+	n2 [label="b", shape=doublecircle];
+	n3 [label="c", shape=doublecircle];
+	n2 -> n3 [label="c"];
+	n1 -> n2 [label="b"];
+	n4 [label="d", shape=doublecircle];
+	n1 -> n4 [label="d"];
+	n0 -> n1 [label="a"];
+}
+`
+	if buf.String() != expected {
+		t.Errorf("Wrong DOT %s, expected %s", buf.String(), expected)
+	}
+}
+
+func TestTrieWriteDOTTestTrie(t *testing.T) {
+	var buf bytes.Buffer
+	if err := createTestTrie().WriteDOT(&buf); err != nil {
+		t.Fatalf("Unable to write DOT: %v", err)
+	}
+	for _, expected := range []string{
+		`[label="Xdxyz", shape=doublecircle];`,
+		`[label="cd", shape=circle];`,
+		`[label="X"];`,
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Expected %s in %s", expected, buf.String())
+		}
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("failed")
+}
+
+func TestTrieWriteDOTError(t *testing.T) {
+	if err := createTestTrie().WriteDOT(failingWriter{}); err == nil {
+		t.Errorf("Expected write error")
+	}
+}
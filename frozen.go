@@ -0,0 +1,213 @@
+package trie
+
+import (
+	"sort"
+	"unsafe"
+)
+
+// FrozenTrie is a read-only copy of a Trie laid out in flat slices instead of linked nodes with children maps,
+// which takes much less memory for large static dictionaries. Its lookups and matches return the same results
+// as those of the Trie it was made from.
+type FrozenTrie struct {
+	nodes    []frozenNode // in breadth first order, so that the children of a node are contiguous
+	labels   []byte       // the prefixes of all nodes
+	values   []Value
+	foldCase bool
+	less     func(a, b byte) bool
+}
+
+// frozenNode is a node of a FrozenTrie. Its prefix is labels[start:end] and its children are
+// nodes[firstChild:firstChild+childCount] in ascending order of their first bytes.
+type frozenNode struct {
+	start      uint32
+	end        uint32
+	firstChild uint32
+	childCount uint16
+	value      int32 // index in values, -1 for no value
+}
+
+// Flatten returns a FrozenTrie holding the keys and values of the Trie, with the same settings.
+// Later modifications of the Trie don't affect the FrozenTrie.
+func (this *Trie) Flatten() *FrozenTrie {
+	frozen := &FrozenTrie{foldCase: this.foldsCase(), less: this.order()}
+	if this == nil {
+		this = NewTrie()
+	}
+	queue := []*Trie{this}
+	for i := 0; i < len(queue); i++ {
+		node := queue[i]
+		children := node.sortedChildren()
+		n := frozenNode{
+			start:      uint32(len(frozen.labels)),
+			end:        uint32(len(frozen.labels) + len(node.prefix)),
+			firstChild: uint32(len(queue)),
+			childCount: uint16(len(children)),
+			value:      -1,
+		}
+		frozen.labels = append(frozen.labels, node.prefix...)
+		if node.value != nil {
+			n.value = int32(len(frozen.values))
+			frozen.values = append(frozen.values, *node.value)
+		}
+		frozen.nodes = append(frozen.nodes, n)
+		queue = append(queue, children...)
+	}
+	return frozen
+}
+
+// Len returns the number of keys in the FrozenTrie.
+func (this *FrozenTrie) Len() int {
+	return len(this.values)
+}
+
+// Get the value associated with the key. If no such key was added, return nil, false.
+func (this *FrozenTrie) GetBytes(key []byte) (value Value, found bool) {
+	return this.get(&inputBytes{key})
+}
+
+// Same as GetBytes but works for string.
+func (this *FrozenTrie) GetString(key string) (value Value, found bool) {
+	return this.get(&inputString{key})
+}
+
+// Test whether the key exists in the FrozenTrie.
+func (this *FrozenTrie) ContainsBytes(key []byte) bool {
+	_, found := this.get(&inputBytes{key})
+	return found
+}
+
+// Same as ContainsBytes but works for string.
+func (this *FrozenTrie) ContainsString(key string) bool {
+	_, found := this.get(&inputString{key})
+	return found
+}
+
+func (this *FrozenTrie) get(key input) (value Value, found bool) {
+	n := key.len()
+	this.walkPrefixes(key, func(m PrefixMatch) bool {
+		if m.PrefixLength == n {
+			value, found = m.Value, true
+		}
+		return true
+	})
+	return value, found
+}
+
+// Match the shortest prefix and associated value. If no prefix is found, return {0, nil}, false.
+func (this *FrozenTrie) MatchShortestPrefixBytes(input []byte) (match PrefixMatch, found bool) {
+	return this.matchPrefix(&inputBytes{input}, shortestPrefix)
+}
+
+// Same as MatchShortestPrefixBytes but works for string.
+func (this *FrozenTrie) MatchShortestPrefixString(input string) (match PrefixMatch, found bool) {
+	return this.matchPrefix(&inputString{input}, shortestPrefix)
+}
+
+// Match the longest prefix and associated value. If no prefix is found, return {0, nil}, false.
+func (this *FrozenTrie) MatchLongestPrefixBytes(input []byte) (match PrefixMatch, found bool) {
+	return this.matchPrefix(&inputBytes{input}, longestPrefix)
+}
+
+// Same as MatchLongestPrefixBytes but works for string.
+func (this *FrozenTrie) MatchLongestPrefixString(input string) (match PrefixMatch, found bool) {
+	return this.matchPrefix(&inputString{input}, longestPrefix)
+}
+
+func (this *FrozenTrie) matchPrefix(in input, mode findNodeMode) (match PrefixMatch, found bool) {
+	this.walkPrefixes(in, func(m PrefixMatch) bool {
+		match, found = m, true
+		return mode != shortestPrefix
+	})
+	return match, found
+}
+
+// Match all possible prefixes and associated values as a list. If no prefix is found, return an empty list.
+func (this *FrozenTrie) MatchAllPrefixesBytes(in []byte) []PrefixMatch {
+	return this.matchAllPrefixes(&inputBytes{in})
+}
+
+// Same as MatchAllPrefixesBytes but works for string.
+func (this *FrozenTrie) MatchAllPrefixesString(in string) []PrefixMatch {
+	return this.matchAllPrefixes(&inputString{in})
+}
+
+func (this *FrozenTrie) matchAllPrefixes(in input) []PrefixMatch {
+	result := []PrefixMatch{}
+	this.walkPrefixes(in, func(m PrefixMatch) bool {
+		result = append(result, m)
+		return true
+	})
+	return result
+}
+
+// walkPrefixes calls fn with every prefix of the input and associated value in increasing length,
+// until fn returns false.
+func (this *FrozenTrie) walkPrefixes(in input, fn func(m PrefixMatch) bool) {
+	if this.foldCase {
+		in = &inputFolded{in}
+	}
+	n := &this.nodes[0]
+	length := 0
+	for {
+		if n.value >= 0 && !fn(PrefixMatch{length, this.values[n.value]}) {
+			return
+		}
+		if in.end() {
+			return
+		}
+		child := this.child(n, in.char())
+		if child == nil || !in.hasPrefix(this.labels[child.start:child.end]) {
+			return
+		}
+		in.advance(int(child.end - child.start))
+		length += int(child.end - child.start)
+		n = child
+	}
+}
+
+// child returns the child of the node whose prefix starts with b, or nil if there's none.
+func (this *FrozenTrie) child(n *frozenNode, b byte) *frozenNode {
+	children := this.nodes[n.firstChild : n.firstChild+uint32(n.childCount)]
+	i := sort.Search(len(children), func(i int) bool {
+		return this.labels[children[i].start] >= b
+	})
+	if i == len(children) || this.labels[children[i].start] != b {
+		return nil
+	}
+	return &children[i]
+}
+
+// Walk visits every key value in the FrozenTrie in the same order as Walk of the Trie it was made from,
+// until fn returns false. The key passed to fn is reused between calls, so fn must copy it to retain it.
+func (this *FrozenTrie) Walk(fn func(key []byte, value Value) bool) {
+	this.walk(&this.nodes[0], nil, fn)
+}
+
+func (this *FrozenTrie) walk(n *frozenNode, key []byte, fn func(key []byte, value Value) bool) bool {
+	key = append(key, this.labels[n.start:n.end]...)
+	if n.value >= 0 && !fn(key, this.values[n.value]) {
+		return false
+	}
+	children := this.nodes[n.firstChild : n.firstChild+uint32(n.childCount)]
+	order := make([]int, len(children))
+	for i := range order {
+		order[i] = i
+	}
+	if this.less != nil {
+		sort.Slice(order, func(i, j int) bool {
+			return this.less(this.labels[children[order[i]].start], this.labels[children[order[j]].start])
+		})
+	}
+	for _, i := range order {
+		if !this.walk(&children[i], key, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// MemoryBytes estimates the number of bytes used by the FrozenTrie, like Trie.MemoryBytes.
+func (this *FrozenTrie) MemoryBytes() int {
+	return int(unsafe.Sizeof(*this)) + cap(this.nodes)*int(unsafe.Sizeof(frozenNode{})) + cap(this.labels) +
+		cap(this.values)*int(unsafe.Sizeof(Value(nil)))
+}
@@ -0,0 +1,104 @@
+package trie
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFrozenTrie(t *testing.T) {
+	trie := createTestTrie()
+	trie.AddString("", "empty")
+	frozen := trie.Flatten()
+	if frozen.Len() != trie.Len() {
+		t.Errorf("Wrong length %d, expected %d", frozen.Len(), trie.Len())
+	}
+	inputs := append(append([]string{content, noPrefixContent, "abcdefgXY", "abcdefgX"}, keys...), nonKeys...)
+	for _, in := range inputs {
+		v, ok := frozen.GetString(in)
+		ev, eok := trie.GetString(in)
+		if v != ev || ok != eok || frozen.ContainsBytes([]byte(in)) != eok {
+			t.Errorf("Wrong value %v, %v for %s, expected %v, %v", v, ok, in, ev, eok)
+		}
+		m, ok := frozen.MatchShortestPrefixBytes([]byte(in))
+		em, eok := trie.MatchShortestPrefixBytes([]byte(in))
+		if m != em || ok != eok {
+			t.Errorf("Wrong shortest match %v, %v for %s, expected %v, %v", m, ok, in, em, eok)
+		}
+		m, ok = frozen.MatchLongestPrefixString(in)
+		em, eok = trie.MatchLongestPrefixString(in)
+		if m != em || ok != eok {
+			t.Errorf("Wrong longest match %v, %v for %s, expected %v, %v", m, ok, in, em, eok)
+		}
+		if r, e := frozen.MatchAllPrefixesString(in), trie.MatchAllPrefixesString(in); !reflect.DeepEqual(r, e) {
+			t.Errorf("Wrong matches %v for %s, expected %v", r, in, e)
+		}
+	}
+	walked := map[string]Value{}
+	frozen.Walk(func(key []byte, value Value) bool {
+		walked[string(key)] = value
+		return true
+	})
+	if !reflect.DeepEqual(walked, trie.ToMap()) {
+		t.Errorf("Wrong walked keys %v", walked)
+	}
+	trie.AddString("abcde", "new")
+	if frozen.ContainsString("abcde") {
+		t.Errorf("FrozenTrie should not observe modifications of the Trie")
+	}
+}
+
+func TestFrozenTrieSettings(t *testing.T) {
+	trie := NewTrieCaseInsensitive()
+	trie.AddString("Hello", 1)
+	if v, ok := trie.Flatten().GetString("HELLO"); !ok || v != 1 {
+		t.Errorf("Wrong value %v, %v, FrozenTrie should ignore case", v, ok)
+	}
+	ordered := NewTrieWithOrder(func(a, b byte) bool { return a > b })
+	for _, k := range keys {
+		ordered.AddString(k, k)
+	}
+	expected, walked := []string{}, []string{}
+	ordered.Walk(func(key []byte, value Value) bool {
+		expected = append(expected, string(key))
+		return true
+	})
+	ordered.Flatten().Walk(func(key []byte, value Value) bool {
+		walked = append(walked, string(key))
+		return true
+	})
+	if !reflect.DeepEqual(walked, expected) {
+		t.Errorf("Wrong order %v, expected %v", walked, expected)
+	}
+	var empty *Trie
+	if frozen := empty.Flatten(); frozen.Len() != 0 || frozen.ContainsString("") {
+		t.Errorf("FrozenTrie of nil trie should be empty")
+	}
+}
+
+func benchmarkLookupKeys() ([][]byte, *Trie) {
+	keys, values := benchmarkKeys(100000)
+	trie := NewTrie()
+	trie.AddAll(keys, values)
+	return keys, trie
+}
+
+func BenchmarkTrieLookup(b *testing.B) {
+	keys, trie := benchmarkLookupKeys()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		trie.GetBytes(keys[n%len(keys)])
+	}
+	// Reported after the loop, as ResetTimer discards the metrics reported before.
+	b.ReportMetric(float64(trie.MemoryBytes()), "trie-bytes")
+}
+
+func BenchmarkFrozenTrieLookup(b *testing.B) {
+	keys, trie := benchmarkLookupKeys()
+	frozen := trie.Flatten()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		frozen.GetBytes(keys[n%len(keys)])
+	}
+	// Reported after the loop, as ResetTimer discards the metrics reported before.
+	b.ReportMetric(float64(frozen.MemoryBytes()), "trie-bytes")
+}
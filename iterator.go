@@ -0,0 +1,61 @@
+package trie
+
+// Iterator iterates over the key values of a Trie in the same order as Walk. The Trie must not be modified
+// during the iteration.
+//
+//	for it := trie.Iterator(); it.Next(); {
+//		fmt.Println(it.Key(), it.Value())
+//	}
+type Iterator struct {
+	stack []iteratorFrame
+	less  func(a, b byte) bool
+	key   []byte
+	value Value
+}
+
+// iteratorFrame is a node waiting to be visited, with the length of the key of its parent.
+type iteratorFrame struct {
+	node      *Trie
+	parentLen int
+}
+
+// Iterator returns an Iterator positioned before the first key of the Trie.
+func (this *Trie) Iterator() *Iterator {
+	if this == nil {
+		return &Iterator{}
+	}
+	return &Iterator{
+		stack: []iteratorFrame{{this, 0}},
+		less:  this.order(),
+	}
+}
+
+// Next advances the Iterator to the next key value and reports whether there is one.
+func (it *Iterator) Next() bool {
+	for len(it.stack) != 0 {
+		frame := it.stack[len(it.stack)-1]
+		it.stack = it.stack[:len(it.stack)-1]
+		node := frame.node
+		it.key = append(it.key[:frame.parentLen], node.prefix...)
+		children := node.orderedChildren(it.less)
+		for i := len(children) - 1; i >= 0; i-- {
+			it.stack = append(it.stack, iteratorFrame{children[i], len(it.key)})
+		}
+		if node.value != nil {
+			it.value = *node.value
+			return true
+		}
+	}
+	it.key, it.value = nil, nil
+	return false
+}
+
+// Key returns the current key. It's reused by Next, so callers must copy it to retain it.
+func (it *Iterator) Key() []byte {
+	return it.key
+}
+
+// Value returns the current value.
+func (it *Iterator) Value() Value {
+	return it.value
+}
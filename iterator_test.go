@@ -0,0 +1,43 @@
+package trie
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestTrieIterator(t *testing.T) {
+	trie := createTestTrie()
+	sorted := append([]string{}, keys...)
+	sort.Strings(sorted)
+	it := trie.Iterator()
+	for i, k := range sorted {
+		if !it.Next() {
+			t.Fatalf("Iteration ended at %d", i)
+		}
+		if string(it.Key()) != k || it.Value().(string) != k {
+			t.Errorf("Wrong key value[%d] %s, %v vs. %s", i, it.Key(), it.Value(), k)
+		}
+	}
+	if it.Next() {
+		t.Errorf("Unexpected key %s after the last one", it.Key())
+	}
+	if NewTrie().Iterator().Next() {
+		t.Errorf("Unexpected key in empty trie")
+	}
+}
+
+func TestTrieIteratorInterleaved(t *testing.T) {
+	trie := createTestTrie()
+	a, b := trie.Iterator(), trie.Iterator()
+	a.Next()
+	a.Next()
+	b.Next()
+	if string(a.Key()) != "abcdefg" || string(b.Key()) != "abXdxyz" {
+		t.Errorf("Wrong keys %s, %s", a.Key(), b.Key())
+	}
+	b.Next()
+	a.Next()
+	if string(a.Key()) != "abcdefgXXX" || string(b.Key()) != "abcdefg" {
+		t.Errorf("Wrong keys %s, %s", a.Key(), b.Key())
+	}
+}
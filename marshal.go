@@ -0,0 +1,155 @@
+package trie
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// jsonBase64Prefix marks a JSON object key holding a base64 encoded Trie key.
+const jsonBase64Prefix = "base64:"
+
+// gobNode is the serialized form of a Trie node.
+type gobNode struct {
+	Prefix   []byte
+	HasValue bool
+	Value    Value
+	Children []gobNode
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler by encoding the node tree with encoding/gob.
+// Values are encoded as interfaces, so their concrete types must be registered with gob.Register
+// unless they are predeclared types such as string or int.
+func (this *Trie) MarshalBinary() ([]byte, error) {
+	if this == nil {
+		this = NewTrie()
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(this.gobNode()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. It replaces the content of the Trie with
+// the one encoded by MarshalBinary. See MarshalBinary for the constraint on values.
+func (this *Trie) UnmarshalBinary(data []byte) error {
+	var root gobNode
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&root); err != nil {
+		return err
+	}
+	if len(root.Prefix) != 0 {
+		return fmt.Errorf("trie: root has prefix %q", root.Prefix)
+	}
+	if err := root.check(nil); err != nil {
+		return err
+	}
+	this.Clear()
+	this.size = this.fromGobNode(&root)
+	return nil
+}
+
+func (this *Trie) gobNode() gobNode {
+	node := gobNode{Prefix: this.prefix}
+	if this.value != nil {
+		node.HasValue = true
+		node.Value = *this.value
+	}
+	for _, child := range this.sortedChildren() {
+		node.Children = append(node.Children, child.gobNode())
+	}
+	return node
+}
+
+// check returns an error if the children of the node, found at path, don't form a valid tree.
+func (node *gobNode) check(path []byte) error {
+	seen := make(map[byte]bool, len(node.Children))
+	for i := range node.Children {
+		child := &node.Children[i]
+		if len(child.Prefix) == 0 {
+			return fmt.Errorf("trie: empty prefix of child under %q", path)
+		}
+		if seen[child.Prefix[0]] {
+			return fmt.Errorf("trie: duplicate child %q under %q", child.Prefix[0], path)
+		}
+		seen[child.Prefix[0]] = true
+		if err := child.check(append(path[:len(path):len(path)], child.Prefix...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fromGobNode fills this node from the serialized node and returns the number of values restored.
+func (this *Trie) fromGobNode(node *gobNode) int {
+	count := 0
+	this.prefix = node.Prefix
+	if node.HasValue {
+		value := node.Value
+		this.value = &value
+		count++
+	}
+	if len(node.Children) != 0 {
+		this.children = make(map[byte]*Trie, len(node.Children))
+	}
+	for i := range node.Children {
+		child := &Trie{}
+		count += child.fromGobNode(&node.Children[i])
+		this.children[child.prefix[0]] = child
+	}
+	return count
+}
+
+// MarshalJSON implements json.Marshaler by encoding the Trie as a JSON object mapping each key to its value.
+// Keys which are not valid UTF-8, or which start with "base64:", are encoded as "base64:" followed by
+// the standard base64 encoding of the key.
+func (this *Trie) MarshalJSON() ([]byte, error) {
+	m := make(map[string]Value, this.Len())
+	this.Walk(func(key []byte, value Value) bool {
+		m[jsonKey(key)] = value
+		return true
+	})
+	return json.Marshal(m)
+}
+
+// UnmarshalJSON implements json.Unmarshaler. It replaces the content of the Trie with the keys and values
+// of a JSON object in the format produced by MarshalJSON. Values are decoded as by json.Unmarshal into an
+// interface{}.
+func (this *Trie) UnmarshalJSON(data []byte) error {
+	var m map[string]Value
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	keys := make(map[string]Value, len(m))
+	for k, v := range m {
+		key, err := trieKey(k)
+		if err != nil {
+			return err
+		}
+		keys[key] = v
+	}
+	this.Clear()
+	for k, v := range keys {
+		this.Add([]byte(k), v)
+	}
+	return nil
+}
+
+func jsonKey(key []byte) string {
+	if !utf8.Valid(key) || bytes.HasPrefix(key, []byte(jsonBase64Prefix)) {
+		return jsonBase64Prefix + base64.StdEncoding.EncodeToString(key)
+	}
+	return string(key)
+}
+
+func trieKey(key string) (string, error) {
+	if !strings.HasPrefix(key, jsonBase64Prefix) {
+		return key, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(key[len(jsonBase64Prefix):])
+	return string(b), err
+}
@@ -0,0 +1,102 @@
+package trie
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"testing"
+)
+
+func TestTrieMarshalBinary(t *testing.T) {
+	trie := createTestTrie()
+	trie.Add([]byte("nil"), nil)
+	trie.Add([]byte("int"), 42)
+	data, err := trie.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Unable to marshal: %v", err)
+	}
+	restored := NewTrie()
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Unable to unmarshal: %v", err)
+	}
+	if restored.Len() != trie.Len() {
+		t.Errorf("Wrong length %d, expected %d", restored.Len(), trie.Len())
+	}
+	for _, k := range keys {
+		if v, ok := restored.GetString(k); !ok || v.(string) != k {
+			t.Errorf("Wrong value %v, %v for key %s", v, ok, k)
+		}
+	}
+	for _, k := range nonKeys {
+		if v, ok := restored.GetString(k); ok {
+			t.Errorf("Unexpected key %s, value %v", k, v)
+		}
+	}
+	if v, ok := restored.GetString("nil"); !ok || v != nil {
+		t.Errorf("Wrong value %v, %v for nil value", v, ok)
+	}
+	if v, ok := restored.GetString("int"); !ok || v.(int) != 42 {
+		t.Errorf("Wrong value %v, %v for int value", v, ok)
+	}
+}
+
+func TestTrieUnmarshalBinaryInvalid(t *testing.T) {
+	trie := createTestTrie()
+	if err := trie.UnmarshalBinary([]byte("invalid")); err == nil {
+		t.Errorf("Expected error for invalid data")
+	}
+}
+
+func TestTrieUnmarshalBinaryInvalidTree(t *testing.T) {
+	for _, root := range []gobNode{
+		{Prefix: []byte("a"), HasValue: true, Value: 1},
+		{Children: []gobNode{{HasValue: true, Value: 1}}},
+		{Children: []gobNode{{Prefix: []byte("a"), Children: []gobNode{{HasValue: true, Value: 1}}}}},
+		{Children: []gobNode{{Prefix: []byte("ab"), HasValue: true, Value: 1}, {Prefix: []byte("ac"), HasValue: true, Value: 2}}},
+	} {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(root); err != nil {
+			t.Fatalf("Unable to encode %v: %v", root, err)
+		}
+		trie := createTestTrie()
+		if err := trie.UnmarshalBinary(buf.Bytes()); err == nil {
+			t.Errorf("Expected error for invalid tree %v", root)
+		}
+		if !trie.Equal(createTestTrie()) {
+			t.Errorf("Trie was modified by invalid tree %v", root)
+		}
+	}
+}
+
+func TestTrieMarshalJSON(t *testing.T) {
+	trie := createTestTrie()
+	trie.Add([]byte{0xff, 'a'}, "invalid utf-8")
+	trie.Add([]byte("base64:x"), "escaped")
+	data, err := json.Marshal(trie)
+	if err != nil {
+		t.Fatalf("Unable to marshal: %v", err)
+	}
+	restored := NewTrie()
+	if err := json.Unmarshal(data, restored); err != nil {
+		t.Fatalf("Unable to unmarshal %s: %v", data, err)
+	}
+	if restored.Len() != trie.Len() {
+		t.Errorf("Wrong length %d, expected %d", restored.Len(), trie.Len())
+	}
+	trie.Walk(func(key []byte, value Value) bool {
+		if v, ok := restored.GetBytes(key); !ok || v != value {
+			t.Errorf("Wrong value %v, %v for key %q, expected %v", v, ok, key, value)
+		}
+		return true
+	})
+}
+
+func TestTrieUnmarshalJSONInvalid(t *testing.T) {
+	trie := NewTrie()
+	if err := json.Unmarshal([]byte(`{"base64:!!": 1}`), trie); err == nil {
+		t.Errorf("Expected error for invalid base64 key")
+	}
+	if err := json.Unmarshal([]byte(`[1]`), trie); err == nil {
+		t.Errorf("Expected error for non-object JSON")
+	}
+}
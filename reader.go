@@ -0,0 +1,78 @@
+package trie
+
+import (
+	"io"
+)
+
+// AddReader adds the key read from r up to io.EOF with the value, as Add does. If reading fails,
+// it returns the error without adding anything.
+func (this *Trie) AddReader(r io.Reader, value Value) error {
+	key, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	this.Add(key, value)
+	return nil
+}
+
+// MatchLongestPrefixReader matches the longest prefix of the bytes read from r, reading only as far as a longer
+// match is still possible. It returns the match, the number of bytes consumed from r, and the error which stopped
+// the reading other than io.EOF. If no prefix is found, the match is {0, nil}.
+func (this *Trie) MatchLongestPrefixReader(r io.Reader) (match PrefixMatch, consumed int, err error) {
+	if this == nil {
+		return match, 0, nil
+	}
+	if this.value != nil {
+		match = PrefixMatch{0, *this.value}
+	}
+	node := this
+	var child *Trie
+	offset := 0
+	for len(node.children) != 0 {
+		c, err := readByte(r)
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return match, consumed, err
+		}
+		consumed++
+		if this.foldsCase() {
+			c = lowerASCII(c)
+		}
+		if child == nil {
+			if child = node.children[c]; child == nil {
+				break
+			}
+			offset = 0
+		}
+		if child.prefix[offset] != c {
+			break
+		}
+		offset++
+		if offset == len(child.prefix) {
+			node, child = child, nil
+			if node.value != nil {
+				match = PrefixMatch{consumed, *node.value}
+			}
+		}
+	}
+	return match, consumed, nil
+}
+
+// readByte reads a single byte from r, retrying reads which return no data and no error.
+func readByte(r io.Reader) (byte, error) {
+	if br, ok := r.(io.ByteReader); ok {
+		return br.ReadByte()
+	}
+	var b [1]byte
+	for {
+		n, err := r.Read(b[:])
+		if n == 1 {
+			return b[0], nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package trie
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestTrieAddReader(t *testing.T) {
+	trie := createTestTrie()
+	if err := trie.AddReader(bytes.NewReader([]byte("abcde")), "read"); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if v, ok := trie.GetBytes([]byte("abcde")); !ok || v != "read" {
+		t.Errorf("Wrong value %v, %v for the key read", v, ok)
+	}
+	failing := io.MultiReader(strings.NewReader("xyz"), iotest.ErrReader(errors.New("failed")))
+	if err := trie.AddReader(failing, "failed"); err == nil || err.Error() != "failed" {
+		t.Errorf("Expected the read error, but got %v", err)
+	}
+	if trie.HasPrefixString("x") || trie.Len() != len(keys)+1 {
+		t.Errorf("Failed read should not add a key")
+	}
+}
+
+func TestTrieMatchLongestPrefixReader(t *testing.T) {
+	trie := createTestTrie()
+	for _, input := range []string{content, noPrefixContent, "abcdefgX", "abcdefgXXX", "abcdefghij", ""} {
+		expected, _ := trie.MatchLongestPrefixString(input)
+		for _, r := range []io.Reader{strings.NewReader(input), iotest.OneByteReader(strings.NewReader(input))} {
+			m, consumed, err := trie.MatchLongestPrefixReader(r)
+			if err != nil {
+				t.Errorf("Unexpected error %v for %s", err, input)
+			}
+			if m != expected {
+				t.Errorf("Wrong match %v for %s, expected %v", m, input, expected)
+			}
+			if consumed < m.PrefixLength || consumed > len(input) {
+				t.Errorf("Wrong consumed bytes %d for %s", consumed, input)
+			}
+		}
+	}
+	// Reading stops at the first byte which can't be matched.
+	r := strings.NewReader("abcdefgkXYZ")
+	m, consumed, _ := trie.MatchLongestPrefixReader(r)
+	if m.PrefixLength != 8 || consumed != 8 || r.Len() != 3 {
+		t.Errorf("Wrong match %v, consumed %d, remaining %d", m, consumed, r.Len())
+	}
+}
+
+func TestTrieMatchLongestPrefixReaderError(t *testing.T) {
+	trie := createTestTrie()
+	r := io.MultiReader(strings.NewReader("abcdefgh"), iotest.ErrReader(errors.New("failed")))
+	m, consumed, err := trie.MatchLongestPrefixReader(r)
+	if err == nil || err.Error() != "failed" {
+		t.Errorf("Expected read error, but %v", err)
+	}
+	if m.PrefixLength != 7 || consumed != 8 {
+		t.Errorf("Wrong match %v, consumed %d before error", m, consumed)
+	}
+}
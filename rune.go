@@ -0,0 +1,74 @@
+package trie
+
+import "unicode/utf8"
+
+// RuneTrie is a Trie whose keys are sequences of runes. Keys are stored UTF-8 encoded in a Trie, but prefix
+// matches never end inside a rune and PrefixLength of the returned matches counts runes instead of bytes.
+type RuneTrie struct {
+	trie Trie
+}
+
+// NewRuneTrie creates an empty RuneTrie.
+func NewRuneTrie() *RuneTrie {
+	return &RuneTrie{}
+}
+
+// Add a key value to RuneTrie. Override the value if the same key is given again.
+func (this *RuneTrie) Add(key string, value Value) {
+	this.trie.AddString(key, value)
+}
+
+// Same as Add but works for runes.
+func (this *RuneTrie) AddRunes(key []rune, value Value) {
+	this.trie.AddString(string(key), value)
+}
+
+// Get the value associated with the key. If no such key was added, return nil, false.
+func (this *RuneTrie) Get(key string) (value Value, found bool) {
+	return this.trie.GetString(key)
+}
+
+// Same as Get but works for runes.
+func (this *RuneTrie) GetRunes(key []rune) (value Value, found bool) {
+	return this.trie.GetString(string(key))
+}
+
+// Delete the key and return the value associated with it. If no such key was added, return nil, false.
+func (this *RuneTrie) Delete(key string) (old Value, existed bool) {
+	return this.trie.DeleteString(key)
+}
+
+// Len returns the number of keys in the RuneTrie.
+func (this *RuneTrie) Len() int {
+	return this.trie.Len()
+}
+
+// Match the shortest prefix in runes and associated value. If no prefix is found, return {0, nil}, false.
+func (this *RuneTrie) MatchShortestPrefix(input string) (match PrefixMatch, found bool) {
+	r := this.MatchAllPrefixes(input)
+	if len(r) == 0 {
+		return PrefixMatch{}, false
+	}
+	return r[0], true
+}
+
+// Match the longest prefix in runes and associated value. If no prefix is found, return {0, nil}, false.
+func (this *RuneTrie) MatchLongestPrefix(input string) (match PrefixMatch, found bool) {
+	r := this.MatchAllPrefixes(input)
+	if len(r) == 0 {
+		return PrefixMatch{}, false
+	}
+	return r[len(r)-1], true
+}
+
+// Match all prefixes in runes and associated values in increasing length. If no prefix is found, return an empty list.
+func (this *RuneTrie) MatchAllPrefixes(input string) []PrefixMatch {
+	result := []PrefixMatch{}
+	for _, m := range this.trie.MatchAllPrefixesString(input) {
+		// Keys which aren't valid UTF-8 may end inside a rune of the input.
+		if n := m.PrefixLength; n == len(input) || utf8.RuneStart(input[n]) {
+			result = append(result, PrefixMatch{utf8.RuneCountInString(input[:n]), m.Value})
+		}
+	}
+	return result
+}
@@ -0,0 +1,62 @@
+package trie
+
+import (
+	"reflect"
+	"testing"
+)
+
+func createTestRuneTrie() *RuneTrie {
+	t := NewRuneTrie()
+	for _, k := range []string{"日本", "日本語", "日本語学校", "über"} {
+		t.Add(k, k)
+	}
+	return t
+}
+
+func TestRuneTrieGet(t *testing.T) {
+	trie := createTestRuneTrie()
+	trie.AddRunes([]rune("ü"), "ü")
+	for _, k := range []string{"日本", "日本語", "日本語学校", "über", "ü"} {
+		if v, ok := trie.Get(k); !ok || v != k {
+			t.Errorf("Wrong value %v, %v for key %s", v, ok, k)
+		}
+		if v, ok := trie.GetRunes([]rune(k)); !ok || v != k {
+			t.Errorf("Wrong value %v, %v for runes %s", v, ok, k)
+		}
+	}
+	if _, ok := trie.Get("日"); ok {
+		t.Errorf("Unexpected key 日")
+	}
+	if v, ok := trie.Delete("ü"); !ok || v != "ü" || trie.Len() != 4 {
+		t.Errorf("Wrong deleted value %v, %v", v, ok)
+	}
+}
+
+func TestRuneTrieMatchPrefix(t *testing.T) {
+	trie := createTestRuneTrie()
+	if m, ok := trie.MatchLongestPrefix("日本語学"); !ok || m.PrefixLength != 3 || m.Value != "日本語" {
+		t.Errorf("Wrong longest match %v, %v", m, ok)
+	}
+	if m, ok := trie.MatchShortestPrefix("日本語学校です"); !ok || m.PrefixLength != 2 || m.Value != "日本" {
+		t.Errorf("Wrong shortest match %v, %v", m, ok)
+	}
+	expected := []PrefixMatch{{2, "日本"}, {3, "日本語"}, {5, "日本語学校"}}
+	if r := trie.MatchAllPrefixes("日本語学校です"); !reflect.DeepEqual(r, expected) {
+		t.Errorf("Wrong matches %v, expected %v", r, expected)
+	}
+	if m, ok := trie.MatchLongestPrefix("übermorgen"); !ok || m.PrefixLength != 4 {
+		t.Errorf("Wrong match %v, %v for übermorgen", m, ok)
+	}
+	if m, ok := trie.MatchLongestPrefix("日"); ok {
+		t.Errorf("Unexpected match %v", m)
+	}
+}
+
+func TestRuneTrieNeverSplitsRunes(t *testing.T) {
+	trie := NewRuneTrie()
+	// The first two bytes of 日 are not a valid key, but a byte-based match would report them.
+	trie.Add("\xe6\x97", "partial")
+	if m, ok := trie.MatchLongestPrefix("日本"); ok {
+		t.Errorf("Match %v splits a rune", m)
+	}
+}
@@ -0,0 +1,57 @@
+package trie
+
+// SuffixTrie matches the ends of inputs against stored suffixes, e.g. file extensions or domain names.
+// It stores the suffixes reversed in a Trie and matches reversed inputs against them.
+type SuffixTrie struct {
+	trie Trie
+}
+
+// NewSuffixTrie creates an empty SuffixTrie.
+func NewSuffixTrie() *SuffixTrie {
+	return &SuffixTrie{}
+}
+
+// Add a suffix value to SuffixTrie. Override the value if the same suffix is given again.
+func (this *SuffixTrie) Add(suffix []byte, value Value) {
+	this.trie.Add(reversed(suffix), value)
+}
+
+// Get the value associated with the suffix. If no such suffix was added, return nil, false.
+func (this *SuffixTrie) GetBytes(suffix []byte) (value Value, found bool) {
+	return this.trie.GetBytes(reversed(suffix))
+}
+
+// Len returns the number of suffixes in the SuffixTrie.
+func (this *SuffixTrie) Len() int {
+	return this.trie.Len()
+}
+
+// Match the longest suffix and associated value. PrefixLength of the returned match is the length of the suffix,
+// which is input[len(input)-PrefixLength:]. If no suffix is found, return {0, nil}, false.
+func (this *SuffixTrie) MatchLongestSuffixBytes(input []byte) (match PrefixMatch, found bool) {
+	return this.trie.MatchLongestPrefixBytes(reversed(input))
+}
+
+// Same as MatchLongestSuffixBytes but works for string.
+func (this *SuffixTrie) MatchLongestSuffixString(input string) (match PrefixMatch, found bool) {
+	return this.trie.MatchLongestPrefixBytes(reversed([]byte(input)))
+}
+
+// Match the shortest suffix and associated value. If no suffix is found, return {0, nil}, false.
+func (this *SuffixTrie) MatchShortestSuffixBytes(input []byte) (match PrefixMatch, found bool) {
+	return this.trie.MatchShortestPrefixBytes(reversed(input))
+}
+
+// Same as MatchShortestSuffixBytes but works for string.
+func (this *SuffixTrie) MatchShortestSuffixString(input string) (match PrefixMatch, found bool) {
+	return this.trie.MatchShortestPrefixBytes(reversed([]byte(input)))
+}
+
+// reversed returns a reversed copy of b.
+func reversed(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i, c := range b {
+		r[len(b)-1-i] = c
+	}
+	return r
+}
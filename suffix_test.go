@@ -0,0 +1,69 @@
+package trie
+
+import (
+	"testing"
+)
+
+func createTestSuffixTrie() *SuffixTrie {
+	t := NewSuffixTrie()
+	for _, s := range []string{".com", ".co.uk", ".uk", ".org"} {
+		t.Add([]byte(s), s)
+	}
+	return t
+}
+
+func TestSuffixTrieMatchLongestSuffix(t *testing.T) {
+	trie := createTestSuffixTrie()
+	cases := map[string]string{
+		"www.example.com":   ".com",
+		"www.example.co.uk": ".co.uk",
+		"gov.uk":            ".uk",
+		"golang.org":        ".org",
+		".org":              ".org",
+	}
+	for input, expected := range cases {
+		m, ok := trie.MatchLongestSuffixString(input)
+		if !ok {
+			t.Errorf("Unable to find suffix of %s", input)
+			continue
+		}
+		if suffix := input[len(input)-m.PrefixLength:]; suffix != expected {
+			t.Errorf("Wrong suffix %s of %s, expected %s", suffix, input, expected)
+		}
+		if m.Value.(string) != expected {
+			t.Errorf("Wrong value %v of %s, expected %s", m.Value, input, expected)
+		}
+		if b, _ := trie.MatchLongestSuffixBytes([]byte(input)); b != m {
+			t.Errorf("Wrong match %v of bytes %s, expected %v", b, input, m)
+		}
+	}
+	for _, input := range []string{"", "com", "example.net", "rg"} {
+		if m, ok := trie.MatchLongestSuffixString(input); ok {
+			t.Errorf("Unexpected suffix %v of %s", m, input)
+		}
+	}
+}
+
+func TestSuffixTrieMatchShortestSuffix(t *testing.T) {
+	trie := createTestSuffixTrie()
+	m, ok := trie.MatchShortestSuffixString("www.example.co.uk")
+	if !ok || m.PrefixLength != 3 || m.Value.(string) != ".uk" {
+		t.Errorf("Wrong shortest suffix %v, %v", m, ok)
+	}
+	if m, ok := trie.MatchShortestSuffixBytes([]byte("uk")); ok {
+		t.Errorf("Unexpected suffix %v", m)
+	}
+}
+
+func TestSuffixTrieGetBytes(t *testing.T) {
+	trie := createTestSuffixTrie()
+	if v, ok := trie.GetBytes([]byte(".co.uk")); !ok || v.(string) != ".co.uk" {
+		t.Errorf("Wrong value %v, %v", v, ok)
+	}
+	if v, ok := trie.GetBytes([]byte("co.uk")); ok {
+		t.Errorf("Unexpected value %v", v)
+	}
+	if trie.Len() != 4 {
+		t.Errorf("Wrong length %d, expected 4", trie.Len())
+	}
+}
@@ -0,0 +1,117 @@
+package trie
+
+import (
+	"sync"
+)
+
+// SyncTrie is a Trie which is safe for concurrent use by multiple goroutines.
+// Lookups may run in parallel while mutations are exclusive.
+type SyncTrie struct {
+	mu   sync.RWMutex
+	trie *Trie
+}
+
+// NewSyncTrie creates an empty SyncTrie.
+func NewSyncTrie() *SyncTrie {
+	return &SyncTrie{trie: NewTrie()}
+}
+
+// Add a key value to SyncTrie. Override the value if the same key is given again.
+func (this *SyncTrie) Add(key []byte, value Value) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.trie.Add(key, value)
+}
+
+// Increment adds delta to the int64 value associated with the key and returns the result. See Trie.Increment.
+func (this *SyncTrie) Increment(key []byte, delta int64) int64 {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.trie.Increment(key, delta)
+}
+
+// Delete the key and return the value associated with it. If no such key was added, return nil, false.
+func (this *SyncTrie) Delete(key []byte) (old Value, existed bool) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.trie.Delete(key)
+}
+
+// Same as Delete but works for string.
+func (this *SyncTrie) DeleteString(key string) (old Value, existed bool) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.trie.DeleteString(key)
+}
+
+// Get the value associated with the key. If no such key was added, return nil, false.
+func (this *SyncTrie) GetBytes(key []byte) (value Value, found bool) {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	return this.trie.GetBytes(key)
+}
+
+// Same as GetBytes but works for string.
+func (this *SyncTrie) GetString(key string) (value Value, found bool) {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	return this.trie.GetString(key)
+}
+
+// Len returns the number of keys in the SyncTrie.
+func (this *SyncTrie) Len() int {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	return this.trie.Len()
+}
+
+// Match the shortest prefix and associated value. If no prefix is found, return {nil, nil}, false.
+func (this *SyncTrie) MatchShortestPrefixBytes(input []byte) (match PrefixMatch, found bool) {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	return this.trie.MatchShortestPrefixBytes(input)
+}
+
+// Same as MatchShortestPrefixBytes but works for string.
+func (this *SyncTrie) MatchShortestPrefixString(input string) (match PrefixMatch, found bool) {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	return this.trie.MatchShortestPrefixString(input)
+}
+
+// Match the longest prefix and associated value. If no prefix is found, return {nil, nil}, false.
+func (this *SyncTrie) MatchLongestPrefixBytes(input []byte) (match PrefixMatch, found bool) {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	return this.trie.MatchLongestPrefixBytes(input)
+}
+
+// Same as MatchLongestPrefixBytes but works for string.
+func (this *SyncTrie) MatchLongestPrefixString(input string) (match PrefixMatch, found bool) {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	return this.trie.MatchLongestPrefixString(input)
+}
+
+// Match all possible prefixes and associated values as a list. If no prefix is found, return an empty list.
+func (this *SyncTrie) MatchAllPrefixesBytes(in []byte) []PrefixMatch {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	return this.trie.MatchAllPrefixesBytes(in)
+}
+
+// Same as MatchAllPrefixesBytes but works for string input.
+func (this *SyncTrie) MatchAllPrefixesString(in string) []PrefixMatch {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	return this.trie.MatchAllPrefixesString(in)
+}
+
+// Snapshot returns a Trie holding the current keys, which later modifications of the SyncTrie don't affect.
+// It shares the nodes instead of copying them, see Trie.Snapshot. The returned Trie is not synchronized.
+func (this *SyncTrie) Snapshot() *Trie {
+	// Taking a snapshot changes the ownership of the nodes, so it's a mutation.
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.trie.Snapshot()
+}
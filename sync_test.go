@@ -0,0 +1,93 @@
+package trie
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// Run with -race to detect unsynchronized access.
+func TestSyncTrieConcurrentAccess(t *testing.T) {
+	trie := NewSyncTrie()
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				for _, k := range keys {
+					trie.Add([]byte(k), k)
+				}
+				trie.DeleteString(keys[i%len(keys)])
+			}
+		}()
+	}
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				for _, k := range keys {
+					if v, ok := trie.GetString(k); ok && v.(string) != k {
+						t.Errorf("Wrong value %v, expected %v", v, k)
+					}
+				}
+				trie.MatchAllPrefixesBytes([]byte(content))
+				trie.MatchLongestPrefixString(content)
+				trie.MatchShortestPrefixString(content)
+			}
+		}()
+	}
+	wg.Wait()
+	for _, k := range keys {
+		trie.Add([]byte(k), k)
+	}
+	if trie.Len() != len(keys) {
+		t.Errorf("Wrong length %d, expected %d", trie.Len(), len(keys))
+	}
+	r := trie.MatchAllPrefixesString(content)
+	if len(r) != len(prefixes) {
+		t.Errorf("Wrong length of prefixes %v vs. %v)", r, prefixes)
+	}
+}
+
+func TestSyncTrieIncrement(t *testing.T) {
+	trie := NewSyncTrie()
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				trie.Increment([]byte("counter"), 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if v, _ := trie.GetString("counter"); v.(int64) != 400 {
+		t.Errorf("Wrong counter %v, expected 400", v)
+	}
+}
+
+func TestSyncTrieSnapshot(t *testing.T) {
+	trie := NewSyncTrie()
+	trie.Add([]byte("a"), 1)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			trie.Add([]byte(fmt.Sprintf("a%d", i)), i)
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		snapshot := trie.Snapshot()
+		n := snapshot.Len()
+		for j := 0; j < 10; j++ {
+			if snapshot.Len() != n || snapshot.Validate() != nil {
+				t.Fatalf("Snapshot changed from %d to %d keys", n, snapshot.Len())
+			}
+		}
+	}
+	wg.Wait()
+}
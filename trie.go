@@ -3,17 +3,40 @@ package trie
 
 import (
 	"bytes"
+	"container/heap"
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
 	"strings"
+	"unicode/utf8"
+	"unsafe"
 )
 
 // Value can be any type. Note that Value when added to the trie and retrieved from the trie.
 type Value interface{}
 
 // Trie is an associative array where the keys are byte arrays. See http://en.wikipedia.org/wiki/Trie for details.
+// A nil *Trie behaves like an empty Trie for lookups, matches and enumerations, while modifying it panics.
+// The empty key is a valid key whose value is held by the root. Being a prefix of every input, it is reported by
+// the prefix matching functions with PrefixLength 0, and it is the first key in lexicographic order.
 type Trie struct {
 	value    *Value
 	prefix   []byte
 	children map[byte]*Trie
+	size     int      // number of values stored under the root, only maintained on the root
+	opts     *options // settings of the trie, only set on the root
+	gen      int      // generation of the node, where the root owns the nodes of its own generation
+}
+
+// options holds the settings of a Trie which differ from the defaults of NewTrie.
+type options struct {
+	foldCase bool
+	less     func(a, b byte) bool // order of bytes for Walk, nil for the natural order
+	bounded  bool                 // whether TryAdd checks maxKeys
+	maxKeys  int                  // maximum number of keys accepted by TryAdd
+	intern   map[string][]byte    // shared prefixes of an interning trie, nil if prefixes are not interned
+	frozen   bool                 // whether modifications panic, never copied to other tries
 }
 
 // NewTrie creates an empty Trie.
@@ -21,106 +44,2199 @@ func NewTrie() *Trie {
 	return &Trie{}
 }
 
-// Add a key value to Trie. Override the value if the same key is given again.
-func (this *Trie) Add(key []byte, value Value) {
-	this.createNode(key).value = &value
+// FromMap creates a Trie holding the keys and values of the map.
+func FromMap(m map[string]Value) *Trie {
+	trie := NewTrie()
+	for k, v := range m {
+		trie.AddString(k, v)
+	}
+	return trie
+}
+
+// BuildSorted creates a Trie holding keys[i] with values[i] for every i in a single pass, which is faster than
+// adding the keys one by one. The keys must be in ascending order, where a repeated key overrides the value as Add
+// does. Return an error if the keys are not sorted or the numbers of keys and values differ.
+func BuildSorted(keys [][]byte, values []Value) (*Trie, error) {
+	if len(keys) != len(values) {
+		return nil, fmt.Errorf("trie: %d keys but %d values", len(keys), len(values))
+	}
+	trie := NewTrie()
+	// spine holds the nodes on the path to the previous key, each with the length of its path from the root.
+	type spineNode struct {
+		node  *Trie
+		depth int
+	}
+	spine := []spineNode{{trie, 0}}
+	var prev []byte
+	for i, key := range keys {
+		if i > 0 && bytes.Compare(prev, key) > 0 {
+			return nil, fmt.Errorf("trie: keys[%d] %q is less than keys[%d] %q", i, key, i-1, prev)
+		}
+		common := longestCommonPrefix(prev, key)
+		var last *Trie
+		for spine[len(spine)-1].depth > common {
+			last = spine[len(spine)-1].node
+			spine = spine[:len(spine)-1]
+		}
+		parent := spine[len(spine)-1]
+		if parent.depth < common {
+			// The keys diverge inside the edge to last, so split it.
+			n := common - parent.depth
+			split := &Trie{
+				prefix:   copyBytes(last.prefix[:n]),
+				children: map[byte]*Trie{last.prefix[n]: last},
+			}
+			last.prefix = copyBytes(last.prefix[n:])
+			parent.node.children[split.prefix[0]] = split
+			parent = spineNode{split, common}
+			spine = append(spine, parent)
+		}
+		node := parent.node
+		if len(key) > common {
+			node = &Trie{prefix: copyBytes(key[common:])}
+			if parent.node.children == nil {
+				parent.node.children = make(map[byte]*Trie)
+			}
+			parent.node.children[key[common]] = node
+			spine = append(spine, spineNode{node, len(key)})
+		}
+		if node.value == nil {
+			trie.size++
+		}
+		value := values[i]
+		node.value = &value
+		prev = key
+	}
+	return trie, nil
+}
+
+// NewTrieCaseInsensitive creates an empty Trie which ignores the case of ASCII letters in keys and inputs.
+// Other bytes are compared exactly. Keys are stored in lower case, which is how Walk and similar methods report them.
+func NewTrieCaseInsensitive() *Trie {
+	return &Trie{opts: &options{foldCase: true}}
+}
+
+// NewTrieWithOrder creates an empty Trie whose Walk and the functions based on it enumerate keys in the
+// lexicographic order defined by less on bytes, instead of the natural order of bytes. As in any lexicographic
+// order, a key still precedes the keys it is a prefix of. Storage and lookups are not affected.
+func NewTrieWithOrder(less func(a, b byte) bool) *Trie {
+	return &Trie{opts: &options{less: less}}
+}
+
+// NewBoundedTrie creates an empty Trie to which TryAdd adds at most maxKeys keys, none if maxKeys is not positive.
+// Other methods adding keys, such as Add, don't check the limit.
+func NewBoundedTrie(maxKeys int) *Trie {
+	return &Trie{opts: &options{bounded: true, maxKeys: maxKeys}}
+}
+
+// NewInterningTrie creates an empty Trie which shares one backing array between all nodes with equal prefixes.
+// This saves memory for large sets of keys with repeated substrings at the cost of a map lookup per new node
+// and a table entry of about 50 bytes per distinct prefix. Prefixes of deleted keys stay in the table
+// until the table is rebuilt, once it holds twice as many prefixes as the Trie can have nodes or on Compact.
+func NewInterningTrie() *Trie {
+	return &Trie{opts: &options{intern: make(map[string][]byte)}}
+}
+
+// newEmpty returns an empty Trie with the same settings as this one.
+func (this *Trie) newEmpty() *Trie {
+	trie := NewTrie()
+	trie.opts = this.copyOpts()
+	return trie
+}
+
+// copyOpts returns a copy of the settings for another Trie, which is not frozen, or nil for the defaults.
+func (this *Trie) copyOpts() *options {
+	if this == nil || this.opts == nil {
+		return nil
+	}
+	opts := *this.opts
+	opts.frozen = false
+	if opts.intern != nil {
+		// Each Trie interns into its own table, so that tries made from one another don't share mutable state.
+		opts.intern = make(map[string][]byte)
+	}
+	return &opts
+}
+
+// Freeze makes the Trie read-only, so that every later modification panics, while lookups work as before.
+// A frozen Trie can be shared without risk of accidental writes. Copies made by Clone, Snapshot and similar
+// methods are not frozen.
+func (this *Trie) Freeze() {
+	// The settings may be shared with sub-trie views, which must not be frozen along with this Trie.
+	opts := options{}
+	if this.opts != nil {
+		opts = *this.opts
+	}
+	opts.frozen = true
+	this.opts = &opts
+}
+
+// checkMutable panics if the Trie is frozen.
+func (this *Trie) checkMutable() {
+	if this.opts != nil && this.opts.frozen {
+		panic("trie: modification of a frozen Trie")
+	}
+}
+
+// order returns the order of bytes used by Walk, or nil for the natural order.
+func (this *Trie) order() func(a, b byte) bool {
+	if this == nil || this.opts == nil {
+		return nil
+	}
+	return this.opts.less
+}
+
+func (this *Trie) foldsCase() bool {
+	return this != nil && this.opts != nil && this.opts.foldCase
+}
+
+// adapt wraps the input according to the settings of the trie.
+func (this *Trie) adapt(in input) input {
+	if this.foldsCase() {
+		return &inputFolded{in}
+	}
+	return in
+}
+
+// Add a key value to Trie. Override the value if the same key is given again.
+// The value may be nil, in which case the key is still considered present.
+func (this *Trie) Add(key []byte, value Value) {
+	this.Replace(key, value)
+}
+
+// TryAdd adds a key value like Add and reports whether it did. A new key is rejected if the Trie was created by
+// NewBoundedTrie and already holds the maximum number of keys, while overriding an existing key always succeeds.
+func (this *Trie) TryAdd(key []byte, value Value) bool {
+	if this.opts != nil && this.opts.bounded && this.size >= this.opts.maxKeys && !this.ContainsBytes(key) {
+		return false
+	}
+	this.Add(key, value)
+	return true
+}
+
+// Same as Add but works for string.
+func (this *Trie) AddString(key string, value Value) {
+	this.Add([]byte(key), value)
+}
+
+// Replace adds a key value like Add and returns the value previously associated with the key.
+// If no such key was added, return nil, false.
+func (this *Trie) Replace(key []byte, value Value) (old Value, existed bool) {
+	node := this.createNode(key)
+	if node.value == nil {
+		this.size++
+	} else {
+		old, existed = *node.value, true
+	}
+	node.value = &value
+	return old, existed
+}
+
+// AddAll adds keys[i] with values[i] for every i as Add does, in the order of keys.
+// Return an error without adding anything if the numbers of keys and values differ.
+func (this *Trie) AddAll(keys [][]byte, values []Value) error {
+	this.checkMutable()
+	if len(keys) != len(values) {
+		return fmt.Errorf("trie: %d keys but %d values", len(keys), len(values))
+	}
+	for i, key := range keys {
+		this.Add(key, values[i])
+	}
+	return nil
+}
+
+// GetOrAdd returns the value associated with the key and true if the key was added before.
+// Otherwise it adds the key value and returns the given value and false.
+func (this *Trie) GetOrAdd(key []byte, value Value) (actual Value, loaded bool) {
+	node := this.createNode(key)
+	if node.value != nil {
+		return *node.value, true
+	}
+	this.size++
+	node.value = &value
+	return value, false
+}
+
+// AddUnique adds a key value like Add if the key was not added before. Otherwise it returns an error naming
+// the key and leaves the value unchanged.
+func (this *Trie) AddUnique(key []byte, value Value) error {
+	if _, loaded := this.GetOrAdd(key, value); loaded {
+		return fmt.Errorf("trie: duplicate key %q", key)
+	}
+	return nil
+}
+
+// GetOrCompute returns the value associated with the key. If the key was not added, it adds the key with the
+// value returned by compute and returns that value. compute is only called for missing keys.
+func (this *Trie) GetOrCompute(key []byte, compute func(key []byte) Value) Value {
+	node := this.createNode(key)
+	if node.value != nil {
+		return *node.value
+	}
+	value := compute(key)
+	this.size++
+	node.value = &value
+	return value
+}
+
+// Update associates the key with the value returned by fn, which receives the current value and whether the key
+// was added before. It traverses the Trie only once.
+func (this *Trie) Update(key []byte, fn func(old Value, existed bool) Value) {
+	node := this.createNode(key)
+	var value Value
+	if node.value == nil {
+		value = fn(nil, false)
+		this.size++
+	} else {
+		value = fn(*node.value, true)
+	}
+	node.value = &value
+}
+
+// Increment adds delta to the int64 value associated with the key and returns the result. A missing key
+// starts from zero. It panics if the key has a value of another type.
+func (this *Trie) Increment(key []byte, delta int64) int64 {
+	var total int64
+	this.Update(key, func(old Value, existed bool) Value {
+		if existed {
+			total = old.(int64)
+		}
+		total += delta
+		return total
+	})
+	return total
+}
+
+// Append adds the value to the values associated with the key, which are stored as a []Value.
+// A value previously set by Add becomes the first of the values. GetBytes returns the whole []Value.
+func (this *Trie) Append(key []byte, value Value) {
+	this.Update(key, func(old Value, existed bool) Value {
+		if !existed {
+			return []Value{value}
+		}
+		if values, ok := old.([]Value); ok {
+			// Values may be shared with clones, so never append in place.
+			return append(values[:len(values):len(values)], value)
+		}
+		return []Value{old, value}
+	})
+}
+
+// Get the values associated with the key by Append in insertion order. A value set by Add is returned as a
+// single element list. If no such key was added, return nil, false.
+func (this *Trie) GetAllBytes(key []byte) (values []Value, found bool) {
+	return allValues(this.GetBytes(key))
+}
+
+// Same as GetAllBytes but works for string.
+func (this *Trie) GetAllString(key string) (values []Value, found bool) {
+	return allValues(this.GetString(key))
+}
+
+func allValues(value Value, found bool) ([]Value, bool) {
+	if !found {
+		return nil, false
+	}
+	if values, ok := value.([]Value); ok {
+		return values, true
+	}
+	return []Value{value}, true
+}
+
+// Len returns the number of keys in the Trie. It takes constant time.
+func (this *Trie) Len() int {
+	if this == nil {
+		return 0
+	}
+	return this.size
+}
+
+// Clone returns a deep copy of the Trie. Modifying either trie afterwards doesn't affect the other.
+// Values themselves are shared, not copied.
+func (this *Trie) Clone() *Trie {
+	if this == nil {
+		return nil
+	}
+	clone := &Trie{
+		value: this.value,
+		size:  this.size,
+		opts:  this.copyOpts(),
+	}
+	if this.prefix != nil {
+		clone.prefix = make([]byte, len(this.prefix))
+		copy(clone.prefix, this.prefix)
+	}
+	if this.children != nil {
+		clone.children = make(map[byte]*Trie, len(this.children))
+		for b, child := range this.children {
+			clone.children[b] = child.Clone()
+		}
+	}
+	return clone
+}
+
+// With returns a new Trie holding the keys of this Trie and the given key value, leaving this Trie unchanged.
+// Only the nodes on the path to the key are copied and the rest are shared between both tries as by Snapshot,
+// so either of them can be modified afterwards without affecting the other.
+func (this *Trie) With(key []byte, value Value) *Trie {
+	if this == nil {
+		this = NewTrie()
+	}
+	with := this.Snapshot()
+	with.Add(key, value)
+	return with
+}
+
+// Snapshot returns a Trie holding the current keys of this Trie, which shares all nodes with it instead of copying
+// them. Afterwards each of the tries copies the shared nodes it modifies first, so neither observes modifications
+// of the other and the snapshot stays a stable view of this Trie while it is being updated.
+func (this *Trie) Snapshot() *Trie {
+	if this == nil {
+		return nil
+	}
+	snapshot := this.copyNode()
+	snapshot.opts = this.copyOpts()
+	// Both roots move to new generations, so the nodes existing so far belong to neither of them.
+	// A frozen Trie never modifies its nodes, so it stays where it is.
+	snapshot.gen = this.gen + 1
+	if this.opts == nil || !this.opts.frozen {
+		this.gen += 2
+	}
+	return snapshot
+}
+
+// Restore makes this Trie hold the keys of the snapshot, sharing its nodes as Snapshot does.
+func (this *Trie) Restore(snapshot *Trie) {
+	this.checkMutable()
+	if snapshot == nil {
+		this.Clear()
+		return
+	}
+	*this = *snapshot.Snapshot()
+}
+
+// copyNode returns a copy of the node with its own children map, sharing the children themselves.
+func (this *Trie) copyNode() *Trie {
+	node := *this
+	node.children = make(map[byte]*Trie, len(this.children)+1)
+	for b, child := range this.children {
+		node.children[b] = child
+	}
+	return &node
+}
+
+// Filter returns a new Trie holding the key values of this Trie for which pred returns true.
+// The new Trie has the same settings as this one and shares no nodes with it.
+func (this *Trie) Filter(pred func(key []byte, value Value) bool) *Trie {
+	result := this.newEmpty()
+	this.Walk(func(key []byte, value Value) bool {
+		if pred(key, value) {
+			result.Add(key, value)
+		}
+		return true
+	})
+	return result
+}
+
+// Split returns two new tries with the same settings as this one, lo holding the key values whose keys are less
+// than pivot and hi holding the rest. Keys are compared bytewise, after lowering the case of pivot if the Trie
+// ignores case. This Trie is not modified.
+func (this *Trie) Split(pivot []byte) (lo, hi *Trie) {
+	if this.foldsCase() {
+		pivot = toLowerASCII(pivot)
+	}
+	lo, hi = this.newEmpty(), this.newEmpty()
+	this.Walk(func(key []byte, value Value) bool {
+		if bytes.Compare(key, pivot) < 0 {
+			lo.Add(key, value)
+		} else {
+			hi.Add(key, value)
+		}
+		return true
+	})
+	return lo, hi
+}
+
+// Intersect returns a new Trie with the same settings as this one, holding the keys present in both tries.
+// The value of each key is combine(a, b), where a is its value in this Trie and b its value in other.
+func (this *Trie) Intersect(other *Trie, combine func(a, b Value) Value) *Trie {
+	result := this.newEmpty()
+	small, large := this, other
+	if other.Len() < this.Len() {
+		small, large = other, this
+	}
+	small.Walk(func(key []byte, value Value) bool {
+		if found, ok := large.GetBytes(key); ok {
+			if small == this {
+				result.Add(key, combine(value, found))
+			} else {
+				result.Add(key, combine(found, value))
+			}
+		}
+		return true
+	})
+	return result
+}
+
+// Difference returns a new Trie with the same settings as this one, holding the key values of this Trie whose keys
+// other doesn't contain.
+func (this *Trie) Difference(other *Trie) *Trie {
+	return this.Filter(func(key []byte, value Value) bool {
+		return !other.ContainsBytes(key)
+	})
+}
+
+// MapValues returns a new Trie with the same keys and settings as this one, where each value is replaced by
+// the result of fn. This Trie is not modified.
+func (this *Trie) MapValues(fn func(key []byte, value Value) Value) *Trie {
+	result := this.Clone()
+	if result == nil {
+		return NewTrie()
+	}
+	result.walkNodes(nil, func(key []byte, node *Trie) {
+		value := fn(key, *node.value)
+		node.value = &value
+	})
+	return result
+}
+
+// walkNodes calls fn with every node holding a value and its key, in lexicographic order of keys.
+func (this *Trie) walkNodes(key []byte, fn func(key []byte, node *Trie)) {
+	if this.value != nil {
+		fn(key, this)
+	}
+	for _, child := range this.sortedChildren() {
+		child.walkNodes(append(key, child.prefix...), fn)
+	}
+}
+
+// Merge adds every key value of other to the Trie. When both tries hold a value for the same key,
+// the value becomes onConflict(existing, incoming), or incoming if onConflict is nil.
+func (this *Trie) Merge(other *Trie, onConflict func(existing, incoming Value) Value) {
+	this.checkMutable()
+	other.Walk(func(key []byte, value Value) bool {
+		node := this.createNode(key)
+		if node.value == nil {
+			this.size++
+		} else if onConflict != nil {
+			value = onConflict(*node.value, value)
+		}
+		node.value = &value
+		return true
+	})
+}
+
+// Equal reports whether both tries hold the same keys with deeply equal values, regardless of insertion order.
+func (this *Trie) Equal(other *Trie) bool {
+	return this.EqualFunc(other, func(a, b Value) bool {
+		return reflect.DeepEqual(a, b)
+	})
+}
+
+// EqualFunc is like Equal but compares the values with eq, which receives the value of this Trie first.
+func (this *Trie) EqualFunc(other *Trie, eq func(a, b Value) bool) bool {
+	if this.Len() != other.Len() {
+		return false
+	}
+	equal := true
+	this.Walk(func(key []byte, value Value) bool {
+		v, found := other.GetBytes(key)
+		equal = found && eq(value, v)
+		return equal
+	})
+	return equal
+}
+
+// Clear removes all keys so the Trie can be reused as if newly created. Settings such as case insensitivity are kept.
+func (this *Trie) Clear() {
+	this.checkMutable()
+	*this = Trie{opts: this.copyOpts()}
+}
+
+// Reserve prepares the Trie for about expectedKeys more keys by sizing the children map of the root for as many
+// distinct first bytes, up to all 256 of them. It saves the growth of the map during bulk loading.
+func (this *Trie) Reserve(expectedKeys int) {
+	this.checkMutable()
+	n := min(expectedKeys, 256)
+	if n <= len(this.children) {
+		return
+	}
+	children := make(map[byte]*Trie, n)
+	for b, child := range this.children {
+		children[b] = child
+	}
+	this.children = children
+}
+
+// Get the value associated with the key. If no such key was added, return nil, false.
+// A key added with a nil value returns nil, true.
+func (this *Trie) GetBytes(key []byte) (value Value, found bool) {
+	return this.get(&inputBytes{key})
+}
+
+// Same as GetBytes but works for string.
+func (this *Trie) GetString(key string) (value Value, found bool) {
+	return this.get(&inputString{key})
+}
+
+func (this *Trie) get(in input) (value Value, found bool) {
+	r := this.findNode(in, exactMatch)
+	if len(r) == 0 {
+		return Value(nil), false
+	}
+	return *r[0].trie.value, true
+}
+
+// KeyDepthBytes returns the number of edges from the root to the node of the key, and whether the key was added.
+// Keys sharing long prefixes are stored fewer edges deep than their lengths, as a node holds a whole prefix.
+func (this *Trie) KeyDepthBytes(key []byte) (depth int, found bool) {
+	return this.keyDepth(&inputBytes{key})
+}
+
+// Same as KeyDepthBytes but works for string.
+func (this *Trie) KeyDepthString(key string) (depth int, found bool) {
+	return this.keyDepth(&inputString{key})
+}
+
+func (this *Trie) keyDepth(key input) (depth int, found bool) {
+	if this == nil {
+		return 0, false
+	}
+	key = this.adapt(key)
+	for !key.end() {
+		child, has := this.children[key.char()]
+		if !has || !key.hasPrefix(child.prefix) {
+			return 0, false
+		}
+		key.advance(len(child.prefix))
+		depth++
+		this = child
+	}
+	if this.value == nil {
+		return 0, false
+	}
+	return depth, true
+}
+
+// GetMany looks up every key as GetBytes does and returns the values and whether each key was found,
+// both in the order of keys. A single input is reused for all lookups.
+func (this *Trie) GetMany(keys [][]byte) ([]Value, []bool) {
+	values := make([]Value, len(keys))
+	found := make([]bool, len(keys))
+	in := &inputBytes{}
+	for i, key := range keys {
+		in.b = key
+		values[i], found[i] = this.get(in)
+	}
+	return values, found
+}
+
+// MustGetBytes returns the value associated with the key. It panics if no such key was added.
+func (this *Trie) MustGetBytes(key []byte) Value {
+	value, found := this.GetBytes(key)
+	if !found {
+		panic(fmt.Sprintf("trie: key %q not found", key))
+	}
+	return value
+}
+
+// Same as MustGetBytes but works for string.
+func (this *Trie) MustGetString(key string) Value {
+	value, found := this.GetString(key)
+	if !found {
+		panic(fmt.Sprintf("trie: key %q not found", key))
+	}
+	return value
+}
+
+// ShortestUniquePrefixBytes returns the shortest prefix of the key which is not a prefix of any other key.
+// If the key was not added, or it's a prefix of another key, return nil, false.
+func (this *Trie) ShortestUniquePrefixBytes(key []byte) ([]byte, bool) {
+	if !this.ContainsBytes(key) {
+		return nil, false
+	}
+	if this.foldsCase() {
+		key = toLowerASCII(key)
+	}
+	// The prefix must reach past the deepest node on the path where another key ends or branches off,
+	// which the first byte of the next edge does.
+	node, length, unique := this, 0, 0
+	for length < len(key) {
+		if node.value != nil || len(node.children) > 1 {
+			unique = length + 1
+		}
+		child := node.children[key[length]]
+		node, length = child, length+len(child.prefix)
+	}
+	if len(node.children) != 0 {
+		return nil, false
+	}
+	return append([]byte(nil), key[:unique]...), true
+}
+
+// Same as ShortestUniquePrefixBytes but works for string.
+func (this *Trie) ShortestUniquePrefixString(key string) (string, bool) {
+	prefix, found := this.ShortestUniquePrefixBytes([]byte(key))
+	return string(prefix), found
+}
+
+// Report whether the key was added.
+func (this *Trie) ContainsBytes(key []byte) bool {
+	return len(this.findNode(&inputBytes{key}, exactMatch)) != 0
+}
+
+// Same as ContainsBytes but works for string.
+func (this *Trie) ContainsString(key string) bool {
+	return len(this.findNode(&inputString{key}, exactMatch)) != 0
+}
+
+// PrefixMatch is the type of returned value of Trie's prefix matching functions.
+type PrefixMatch struct {
+	PrefixLength int
+	Value        Value
+}
+
+// Match the shortest prefix and associated value. If no prefix is found, return {nil, nil}, false.
+func (this *Trie) MatchShortestPrefixBytes(input []byte) (match PrefixMatch, found bool) {
+	return this.matchPrefix(&inputBytes{input}, shortestPrefix)
+}
+
+// Same as MatchShortestPrefixBytes but works for string.
+func (this *Trie) MatchShortestPrefixString(input string) (match PrefixMatch, found bool) {
+	return this.matchPrefix(&inputString{input}, shortestPrefix)
+}
+
+// Match the longest prefix and associated value. If no prefix is found, return {nil, nil}, false.
+func (this *Trie) MatchLongestPrefixBytes(input []byte) (match PrefixMatch, found bool) {
+	return this.matchPrefix(&inputBytes{input}, longestPrefix)
+}
+
+// Same as MatchLongestPrefixBytes but works for string.
+func (this *Trie) MatchLongestPrefixString(input string) (match PrefixMatch, found bool) {
+	return this.matchPrefix(&inputString{input}, longestPrefix)
+}
+
+// AncestorValueBytes returns the longest key which is a proper prefix of the given key and its value, skipping
+// the key itself, as in inheriting settings from an enclosing namespace. If no such key is found, return {0, nil}, false.
+func (this *Trie) AncestorValueBytes(key []byte) (match PrefixMatch, found bool) {
+	return this.ancestorValue(&inputBytes{key}, len(key))
+}
+
+// Same as AncestorValueBytes but works for string.
+func (this *Trie) AncestorValueString(key string) (match PrefixMatch, found bool) {
+	return this.ancestorValue(&inputString{key}, len(key))
+}
+
+func (this *Trie) ancestorValue(key input, length int) (match PrefixMatch, found bool) {
+	r := this.matchAllPrefixes(key)
+	for i := len(r) - 1; i >= 0; i-- {
+		if r[i].PrefixLength < length {
+			return r[i], true
+		}
+	}
+	return PrefixMatch{}, false
+}
+
+// Same as MatchLongestPrefixBytes but also returns the keys starting with the matched prefix in lexicographic
+// order, the matched prefix being the first of them. If no prefix is found, return {0, nil}, nil, false.
+func (this *Trie) MatchLongestPrefixWithCompletionsBytes(input []byte) (match PrefixMatch, completions [][]byte, found bool) {
+	r := this.findNode(&inputBytes{input}, longestPrefix)
+	if len(r) == 0 {
+		return PrefixMatch{}, nil, false
+	}
+	prefix := copyBytes(input[:r[0].prefixLength])
+	if this.foldsCase() {
+		prefix = toLowerASCII(prefix)
+	}
+	completions = [][]byte{}
+	r[0].trie.walk(prefix, this.order(), func(key []byte, value Value) bool {
+		completions = append(completions, copyBytes(key))
+		return true
+	})
+	return PrefixMatch{r[0].prefixLength, *r[0].trie.value}, completions, true
+}
+
+// Match the longest prefix which is at most maxLen bytes long, ignoring longer matches. A maxLen of 0 only matches
+// the empty key. If no such prefix is found, or maxLen is negative, return {0, nil}, false.
+func (this *Trie) MatchLongestPrefixMaxLenBytes(input []byte, maxLen int) (match PrefixMatch, found bool) {
+	if maxLen < 0 {
+		return PrefixMatch{}, false
+	}
+	if len(input) > maxLen {
+		input = input[:maxLen]
+	}
+	return this.matchPrefix(&inputBytes{input}, longestPrefix)
+}
+
+// Same as MatchLongestPrefixMaxLenBytes but works for string.
+func (this *Trie) MatchLongestPrefixMaxLenString(input string, maxLen int) (match PrefixMatch, found bool) {
+	if maxLen < 0 {
+		return PrefixMatch{}, false
+	}
+	if len(input) > maxLen {
+		input = input[:maxLen]
+	}
+	return this.matchPrefix(&inputString{input}, longestPrefix)
+}
+
+// LookupOrLongestPrefixBytes returns the value of the key if it was added, or otherwise the value of the longest
+// prefix of the key, as in longest prefix match routing. The returned length is len(key) for an exact match.
+// If neither is found, return nil, 0, false.
+func (this *Trie) LookupOrLongestPrefixBytes(key []byte) (value Value, length int, found bool) {
+	m, found := this.matchPrefix(&inputBytes{key}, longestPrefix)
+	return m.Value, m.PrefixLength, found
+}
+
+// Same as LookupOrLongestPrefixBytes but works for string.
+func (this *Trie) LookupOrLongestPrefixString(key string) (value Value, length int, found bool) {
+	m, found := this.matchPrefix(&inputString{key}, longestPrefix)
+	return m.Value, m.PrefixLength, found
+}
+
+// Token is a segment of the input of Tokenize. Matched tells whether the segment is a key with the value,
+// or a single byte which no key matched.
+type Token struct {
+	Start   int
+	Length  int
+	Value   Value
+	Matched bool
+}
+
+// Tokenize splits the input into the longest keys matching at each position, from the start of the input.
+// Where no key matches, the byte there becomes an unmatched token of length 1. The empty key never matches.
+func (this *Trie) Tokenize(input []byte) []Token {
+	result := []Token{}
+	for start := 0; start < len(input); {
+		m, found := this.matchPrefix(&inputBytes{input[start:]}, longestPrefix)
+		if !found || m.PrefixLength == 0 {
+			result = append(result, Token{Start: start, Length: 1})
+			start++
+			continue
+		}
+		result = append(result, Token{start, m.PrefixLength, m.Value, true})
+		start += m.PrefixLength
+	}
+	return result
+}
+
+// Same as Tokenize but works for string.
+func (this *Trie) TokenizeString(input string) []Token {
+	return this.Tokenize([]byte(input))
+}
+
+// WalkPrefixesBytes calls fn with every prefix of the input and associated value in increasing length,
+// until fn returns false. It's the same as iterating over MatchAllPrefixesBytes without building the list.
+func (this *Trie) WalkPrefixesBytes(input []byte, fn func(m PrefixMatch) bool) {
+	this.walkPrefixes(&inputBytes{input}, fn)
+}
+
+// Same as WalkPrefixesBytes but works for string.
+func (this *Trie) WalkPrefixesString(input string, fn func(m PrefixMatch) bool) {
+	this.walkPrefixes(&inputString{input}, fn)
+}
+
+func (this *Trie) walkPrefixes(in input, fn func(m PrefixMatch) bool) {
+	if this == nil {
+		return
+	}
+	in = this.adapt(in)
+	length := 0
+	for {
+		if this.value != nil && !fn(PrefixMatch{length, *this.value}) {
+			return
+		}
+		if in.end() {
+			return
+		}
+		child, has := this.children[in.char()]
+		if !has || !in.hasPrefix(child.prefix) {
+			return
+		}
+		in.advance(len(child.prefix))
+		length += len(child.prefix)
+		this = child
+	}
+}
+
+// Match the longest prefix which ends on a rune boundary of the UTF-8 input, so that the match never
+// splits a multi-byte rune. Longer matches ending in the middle of a rune are skipped in favor of the longest
+// match ending on a boundary. If no such prefix is found, return {0, nil}, false.
+func (this *Trie) MatchLongestPrefixRunesBytes(input []byte) (match PrefixMatch, found bool) {
+	r := this.matchAllPrefixes(&inputBytes{input})
+	for i := len(r) - 1; i >= 0; i-- {
+		if n := r[i].PrefixLength; n == len(input) || utf8.RuneStart(input[n]) {
+			return r[i], true
+		}
+	}
+	return PrefixMatch{}, false
+}
+
+// Same as MatchLongestPrefixRunesBytes but works for string.
+func (this *Trie) MatchLongestPrefixRunesString(input string) (match PrefixMatch, found bool) {
+	r := this.matchAllPrefixes(&inputString{input})
+	for i := len(r) - 1; i >= 0; i-- {
+		if n := r[i].PrefixLength; n == len(input) || utf8.RuneStart(input[n]) {
+			return r[i], true
+		}
+	}
+	return PrefixMatch{}, false
+}
+
+func (this *Trie) matchPrefix(in input, mode findNodeMode) (match PrefixMatch, found bool) {
+	r := this.findNode(in, mode)
+	if len(r) == 0 {
+		return PrefixMatch{}, false
+	}
+	return PrefixMatch{
+		PrefixLength: r[0].prefixLength,
+		Value:        *r[0].trie.value,
+	}, true
+}
+
+// Match all possible prefixes and associated values as a list. If no prefix is found, return an empty list.
+func (this *Trie) MatchAllPrefixesBytes(in []byte) []PrefixMatch {
+	return this.matchAllPrefixes(&inputBytes{in})
+}
+
+// Same as MatchAllPrefixesBytes but works for string input.
+func (this *Trie) MatchAllPrefixesString(in string) []PrefixMatch {
+	return this.matchAllPrefixes(&inputString{in})
+}
+
+func (this *Trie) matchAllPrefixes(in input) []PrefixMatch {
+	r := this.findNode(in, allPrefixex)
+	result := make([]PrefixMatch, len(r))
+	for i, v := range r {
+		result[i].PrefixLength = v.prefixLength
+		result[i].Value = *v.trie.value
+	}
+	return result
+}
+
+// PrefixMatchKey is a PrefixMatch which also holds the matched key.
+type PrefixMatchKey struct {
+	PrefixLength int
+	Key          []byte
+	Value        Value
+}
+
+// Same as MatchAllPrefixesBytes but each match also holds the matched key. The key is input[:PrefixLength],
+// which shares memory with the input rather than being copied.
+func (this *Trie) MatchAllPrefixKeysBytes(input []byte) []PrefixMatchKey {
+	r := this.matchAllPrefixes(&inputBytes{input})
+	result := make([]PrefixMatchKey, len(r))
+	for i, m := range r {
+		result[i] = PrefixMatchKey{m.PrefixLength, input[:m.PrefixLength:m.PrefixLength], m.Value}
+	}
+	return result
+}
+
+// KeyValue is a key with its value, the type of returned value of MatchAllPrefixesWithKeysString.
+type KeyValue struct {
+	Key   string
+	Value Value
+}
+
+// Same as MatchAllPrefixesString but returns each matched prefix of the input as a string instead of its length.
+func (this *Trie) MatchAllPrefixesWithKeysString(input string) []KeyValue {
+	r := this.matchAllPrefixes(&inputString{input})
+	result := make([]KeyValue, len(r))
+	for i, m := range r {
+		result[i] = KeyValue{input[:m.PrefixLength], m.Value}
+	}
+	return result
+}
+
+// Match the prefix whose value has the highest score, preferring the longer prefix on ties.
+// If no prefix is found, return {0, nil}, false.
+func (this *Trie) MatchBestPrefixBytes(input []byte, score func(Value) int) (match PrefixMatch, found bool) {
+	return bestPrefix(this.matchAllPrefixes(&inputBytes{input}), score)
+}
+
+// Same as MatchBestPrefixBytes but works for string.
+func (this *Trie) MatchBestPrefixString(input string, score func(Value) int) (match PrefixMatch, found bool) {
+	return bestPrefix(this.matchAllPrefixes(&inputString{input}), score)
+}
+
+func bestPrefix(matches []PrefixMatch, score func(Value) int) (match PrefixMatch, found bool) {
+	best := 0
+	for _, m := range matches {
+		// Matches are in increasing length, so later ones win ties.
+		if s := score(m.Value); !found || s >= best {
+			match, best, found = m, s, true
+		}
+	}
+	return match, found
+}
+
+// Occurrence is the type of returned value of Trie's FindAllOccurrences functions.
+type Occurrence struct {
+	Start  int
+	Length int
+	Value  Value
+}
+
+// Find all occurrences of keys anywhere in the text, ordered by start position and then by length.
+// It matches prefixes at every position of the text, which takes O(len(text) * length of the longest key).
+func (this *Trie) FindAllOccurrencesBytes(text []byte) []Occurrence {
+	result := []Occurrence{}
+	for start := range text {
+		for _, m := range this.matchAllPrefixes(&inputBytes{text[start:]}) {
+			result = append(result, Occurrence{start, m.PrefixLength, m.Value})
+		}
+	}
+	return result
+}
+
+// Same as FindAllOccurrencesBytes but works for string.
+func (this *Trie) FindAllOccurrencesString(text string) []Occurrence {
+	result := []Occurrence{}
+	for start := 0; start < len(text); start++ {
+		for _, m := range this.matchAllPrefixes(&inputString{text[start:]}) {
+			result = append(result, Occurrence{start, m.PrefixLength, m.Value})
+		}
+	}
+	return result
+}
+
+// ReplaceAllBytes returns a copy of the text where keys are replaced by the results of replace. The text is
+// scanned from left to right, replacing the longest key starting at each position and continuing after it.
+// Bytes not covered by any key are copied unchanged. The empty key never matches.
+func (this *Trie) ReplaceAllBytes(text []byte, replace func(match []byte, value Value) []byte) []byte {
+	result := make([]byte, 0, len(text))
+	for start := 0; start < len(text); {
+		m, found := this.matchPrefix(&inputBytes{text[start:]}, longestPrefix)
+		if !found || m.PrefixLength == 0 {
+			result = append(result, text[start])
+			start++
+			continue
+		}
+		result = append(result, replace(text[start:start+m.PrefixLength], m.Value)...)
+		start += m.PrefixLength
+	}
+	return result
+}
+
+// Same as ReplaceAllBytes but works for string.
+func (this *Trie) ReplaceAllString(text string, replace func(match string, value Value) string) string {
+	var buf bytes.Buffer
+	for start := 0; start < len(text); {
+		m, found := this.matchPrefix(&inputString{text[start:]}, longestPrefix)
+		if !found || m.PrefixLength == 0 {
+			buf.WriteByte(text[start])
+			start++
+			continue
+		}
+		buf.WriteString(replace(text[start:start+m.PrefixLength], m.Value))
+		start += m.PrefixLength
+	}
+	return buf.String()
+}
+
+// Delete the key and return the value associated with it. If no such key was added, return nil, false.
+// Nodes left without a value are pruned so the trie stays compact.
+func (this *Trie) Delete(key []byte) (old Value, existed bool) {
+	return this.delete(&inputBytes{key})
+}
+
+// Same as Delete but works for string.
+func (this *Trie) DeleteString(key string) (old Value, existed bool) {
+	return this.delete(&inputString{key})
+}
+
+func (this *Trie) delete(key input) (old Value, existed bool) {
+	this.checkMutable()
+	key = this.adapt(key)
+	var parent *Trie
+	node := this
+	for !key.end() {
+		child, has := node.children[key.char()]
+		if !has || !key.hasPrefix(child.prefix) {
+			return Value(nil), false
+		}
+		key.advance(len(child.prefix))
+		parent, node = node, this.own(node, child)
+	}
+	if node.value == nil {
+		return Value(nil), false
+	}
+	old = *node.value
+	node.value = nil
+	this.size--
+	if parent == nil {
+		return old, true
+	}
+	switch len(node.children) {
+	case 0:
+		this.removeChild(parent, node)
+	case 1:
+		node.mergeChild()
+	}
+	this.trimIntern()
+	return old, true
+}
+
+// Delete all keys starting with the prefix and return the number of deleted keys.
+func (this *Trie) DeletePrefixBytes(prefix []byte) int {
+	return this.deletePrefix(&inputBytes{prefix})
+}
+
+// Same as DeletePrefixBytes but works for string.
+func (this *Trie) DeletePrefixString(prefix string) int {
+	return this.deletePrefix(&inputString{prefix})
+}
+
+func (this *Trie) deletePrefix(prefix input) int {
+	this.checkMutable()
+	prefix = this.adapt(prefix)
+	var parent *Trie
+	node := this
+	for !prefix.end() {
+		child, has := node.children[prefix.char()]
+		if !has {
+			return 0
+		}
+		if !prefix.hasPrefix(child.prefix) {
+			if !prefix.prefixOf(child.prefix) {
+				return 0
+			}
+			parent, node = node, child
+			break
+		}
+		prefix.advance(len(child.prefix))
+		parent, node = node, this.own(node, child)
+	}
+	count := node.countValues()
+	if parent == nil {
+		this.Clear()
+		return count
+	}
+	this.removeChild(parent, node)
+	this.size -= count
+	this.trimIntern()
+	return count
+}
+
+// countValues returns the number of values in the subtree of this node.
+func (this *Trie) countValues() int {
+	count := 0
+	if this.value != nil {
+		count++
+	}
+	for _, child := range this.children {
+		count += child.countValues()
+	}
+	return count
+}
+
+// CountApprox counts the keys by visiting at most maxNodes nodes, unlike Len which relies on the counter of the root.
+// If the budget suffices for the whole Trie, it returns the exact count and true. Otherwise it extrapolates the
+// count from the visited part and returns false. For the estimate every node stands for an equal part of its
+// parent's subtree, shared with each of its siblings and the parent's own value.
+func (this *Trie) CountApprox(maxNodes int) (count int, exact bool) {
+	budget := maxNodes
+	counted, covered, complete := this.countApprox(&budget, 1)
+	if complete {
+		return counted, true
+	}
+	if covered == 0 {
+		return 0, false
+	}
+	return int(float64(counted)/covered + 0.5), false
+}
+
+// countApprox counts the values of the visited nodes in the subtree of this node, which stands for the share
+// of the whole Trie, and returns which part of the Trie they stand for and whether the subtree was completed.
+func (this *Trie) countApprox(budget *int, share float64) (count int, covered float64, complete bool) {
+	if this == nil {
+		return 0, share, true
+	}
+	if *budget <= 0 {
+		return 0, 0, false
+	}
+	*budget--
+	part := share / float64(len(this.children)+1)
+	if this.value != nil {
+		count++
+	}
+	covered, complete = part, true
+	for _, child := range this.sortedChildren() {
+		c, v, ok := child.countApprox(budget, part)
+		count += c
+		covered += v
+		complete = complete && ok
+	}
+	return count, covered, complete
+}
+
+// Compact merges every node without a value and with a single child into that child, and removes nodes
+// without a value and children, so that the Trie uses as few nodes as possible. Tries modified only through
+// this package are kept compact already, so this is only needed after building nodes by other means.
+func (this *Trie) Compact() {
+	this.checkMutable()
+	this.ownAll(this)
+	this.compact(this)
+	if this.opts != nil && this.opts.intern != nil {
+		this.rebuildIntern()
+	}
+}
+
+func (this *Trie) compact(root *Trie) {
+	for b, child := range this.children {
+		child.compact(root)
+		if child.value == nil && len(child.children) == 0 {
+			delete(this.children, b)
+		}
+	}
+	if this != root && this.value == nil && len(this.children) == 1 {
+		this.mergeChild()
+	}
+}
+
+// Prune deletes every key value for which pred returns true and returns the number of deleted keys.
+// Branches left without keys are removed, and the rest of the Trie is kept compact.
+func (this *Trie) Prune(pred func(key []byte, value Value) bool) int {
+	this.checkMutable()
+	count := 0
+	this.ownAll(this)
+	this.walkNodes(nil, func(key []byte, node *Trie) {
+		if pred(key, *node.value) {
+			node.value = nil
+			count++
+		}
+	})
+	if count != 0 {
+		this.size -= count
+		this.compact(this)
+		this.trimIntern()
+	}
+	return count
+}
+
+// Validate checks the invariants of the Trie and returns an error describing the first violation found, or nil.
+// The root has an empty prefix and counts the keys of the Trie. Every other node has a non-empty prefix, is keyed
+// by its first byte in the children of its parent, and has a value or at least two children.
+func (this *Trie) Validate() error {
+	if this == nil {
+		return nil
+	}
+	if len(this.prefix) != 0 {
+		return fmt.Errorf("trie: root has prefix %q", this.prefix)
+	}
+	if err := this.validate(nil); err != nil {
+		return err
+	}
+	if count := this.countValues(); count != this.size {
+		return fmt.Errorf("trie: root counts %d keys but holds %d", this.size, count)
+	}
+	return nil
+}
+
+func (this *Trie) validate(path []byte) error {
+	for b, child := range this.children {
+		switch {
+		case child == nil:
+			return fmt.Errorf("trie: nil child %q under %q", b, path)
+		case len(child.prefix) == 0:
+			return fmt.Errorf("trie: empty prefix of child %q under %q", b, path)
+		case child.prefix[0] != b:
+			return fmt.Errorf("trie: child %q keyed by %q under %q", child.prefix, b, path)
+		case child.value == nil && len(child.children) == 0:
+			return fmt.Errorf("trie: node %q has neither value nor children", append(path, child.prefix...))
+		case child.value == nil && len(child.children) == 1:
+			return fmt.Errorf("trie: node %q has no value and a single child", append(path, child.prefix...))
+		}
+		if err := child.validate(append(path, child.prefix...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeChild removes the child and its subtree from the parent, which is a descendant of the root this.
+// The parent is then merged with its remaining child if it has no value, to keep the trie compact.
+func (this *Trie) removeChild(parent, child *Trie) {
+	delete(parent.children, child.prefix[0])
+	if parent != this && parent.value == nil && len(parent.children) == 1 {
+		parent.mergeChild()
+	}
+}
+
+// mergeChild folds the only child of this node into the node itself.
+func (this *Trie) mergeChild() {
+	for _, child := range this.children {
+		prefix := make([]byte, 0, len(this.prefix)+len(child.prefix))
+		prefix = append(prefix, this.prefix...)
+		this.prefix = append(prefix, child.prefix...)
+		this.value = child.value
+		this.children = child.children
+		if child.gen != this.gen {
+			// The children map of a shared node must not be modified.
+			this.children = child.copyNode().children
+		}
+	}
+}
+
+// own returns the child of the parent, which the root this owns. If the child is shared with a snapshot,
+// it's replaced by a copy owned by the root first, so that it can be modified.
+func (this *Trie) own(parent, child *Trie) *Trie {
+	if child.gen == this.gen {
+		return child
+	}
+	child = child.copyNode()
+	child.gen = this.gen
+	parent.children[child.prefix[0]] = child
+	return child
+}
+
+// ownAll makes the root this own every node in the subtree of the node.
+func (this *Trie) ownAll(node *Trie) {
+	if this.gen == 0 {
+		// No snapshot was taken, so no node is shared.
+		return
+	}
+	for _, child := range node.children {
+		this.ownAll(this.own(node, child))
+	}
+}
+
+// Walk visits every key value in the Trie in lexicographic order of keys until fn returns false.
+// The key passed to fn is reused between calls, so fn must copy it to retain it.
+func (this *Trie) Walk(fn func(key []byte, value Value) bool) {
+	this.walk(nil, this.order(), fn)
+}
+
+func (this *Trie) walk(key []byte, less func(a, b byte) bool, fn func(key []byte, value Value) bool) bool {
+	if this == nil {
+		return true
+	}
+	if this.value != nil && !fn(key, *this.value) {
+		return false
+	}
+	for _, child := range this.orderedChildren(less) {
+		if !child.walk(append(key, child.prefix...), less, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// WalkContext is like Walk but checks ctx before visiting each key. It stops and returns ctx.Err() once ctx is done,
+// or returns nil after visiting all keys or when fn returns false.
+func (this *Trie) WalkContext(ctx context.Context, fn func(key []byte, value Value) bool) error {
+	var err error
+	this.Walk(func(key []byte, value Value) bool {
+		if err = ctx.Err(); err != nil {
+			return false
+		}
+		return fn(key, value)
+	})
+	return err
+}
+
+// WalkNodes visits every node of the Trie, including the root and the nodes without value, parents before children
+// and siblings in the order of Walk, until fn returns false. fn receives the path from the root to the node,
+// whether the node holds a value and its number of children. The path is reused between calls like the key of Walk.
+func (this *Trie) WalkNodes(fn func(path []byte, hasValue bool, childCount int) bool) {
+	this.walkAllNodes(nil, this.order(), fn)
+}
+
+func (this *Trie) walkAllNodes(path []byte, less func(a, b byte) bool, fn func(path []byte, hasValue bool, childCount int) bool) bool {
+	if this == nil {
+		return true
+	}
+	if !fn(path, this.value != nil, len(this.children)) {
+		return false
+	}
+	for _, child := range this.orderedChildren(less) {
+		if !child.walkAllNodes(append(path, child.prefix...), less, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// WalkReverse is like Walk but visits the keys in descending order, so every key follows the keys it is a prefix of.
+func (this *Trie) WalkReverse(fn func(key []byte, value Value) bool) {
+	this.walkReverse(nil, this.order(), fn)
+}
+
+func (this *Trie) walkReverse(key []byte, less func(a, b byte) bool, fn func(key []byte, value Value) bool) bool {
+	if this == nil {
+		return true
+	}
+	children := this.orderedChildren(less)
+	for i := len(children) - 1; i >= 0; i-- {
+		if !children[i].walkReverse(append(key, children[i].prefix...), less, fn) {
+			return false
+		}
+	}
+	return this.value == nil || fn(key, *this.value)
+}
+
+// WalkDepth is like Walk but only visits keys at most maxDepth edges below the root, and passes fn the depth
+// of each key. The key of the root is at depth 0.
+func (this *Trie) WalkDepth(maxDepth int, fn func(key []byte, value Value, depth int) bool) {
+	this.walkDepth(nil, 0, maxDepth, this.order(), fn)
+}
+
+func (this *Trie) walkDepth(key []byte, depth, maxDepth int, less func(a, b byte) bool, fn func(key []byte, value Value, depth int) bool) bool {
+	if this == nil {
+		return true
+	}
+	if this.value != nil && !fn(key, *this.value, depth) {
+		return false
+	}
+	if depth == maxDepth {
+		return true
+	}
+	for _, child := range this.orderedChildren(less) {
+		if !child.walkDepth(append(key, child.prefix...), depth+1, maxDepth, less, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// WalkRange visits the key values with lo <= key <= hi in lexicographic order of keys until fn returns false,
+// after lowering the case of lo and hi if the Trie is case-insensitive. Subtrees outside the range are skipped.
+// The key passed to fn is reused between calls as in Walk.
+func (this *Trie) WalkRange(lo, hi []byte, fn func(key []byte, value Value) bool) {
+	if this.foldsCase() {
+		lo, hi = toLowerASCII(lo), toLowerASCII(hi)
+	}
+	this.walkRange(nil, lo, hi, fn)
+}
+
+// walkRange returns false if the traversal should stop, because fn returned false or the keys passed hi.
+func (this *Trie) walkRange(key, lo, hi []byte, fn func(key []byte, value Value) bool) bool {
+	if this == nil {
+		return true
+	}
+	if bytes.Compare(key, hi) > 0 {
+		return false
+	}
+	if bytes.Compare(key, lo[:min(len(key), len(lo))]) < 0 {
+		// Every key in the subtree is below lo.
+		return true
+	}
+	if this.value != nil && bytes.Compare(key, lo) >= 0 && !fn(key, *this.value) {
+		return false
+	}
+	for _, child := range this.sortedChildren() {
+		if !child.walkRange(append(key, child.prefix...), lo, hi, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// ToMap returns a map holding the keys and values of the Trie. Keys which are not valid UTF-8 are kept
+// unchanged, since Go strings may hold arbitrary bytes, so FromMap(trie.ToMap()) equals the trie.
+func (this *Trie) ToMap() map[string]Value {
+	result := make(map[string]Value, this.Len())
+	this.Walk(func(key []byte, value Value) bool {
+		result[string(key)] = value
+		return true
+	})
+	return result
+}
+
+// Conflict is a pair of keys where Prefix is a strict prefix of Key.
+type Conflict struct {
+	Prefix []byte
+	Key    []byte
+}
+
+// CheckNoPrefixConflicts returns every pair of keys where one is a strict prefix of the other, ordered by the
+// longer key and then by the shorter one. If no key is a prefix of another, return an empty list.
+func (this *Trie) CheckNoPrefixConflicts() []Conflict {
+	result := []Conflict{}
+	ancestors := [][]byte{}
+	this.walkConflicts(nil, &ancestors, &result)
+	return result
+}
+
+// walkConflicts collects the conflicts in the subtree of this node, where ancestors are the keys above it.
+func (this *Trie) walkConflicts(key []byte, ancestors *[][]byte, result *[]Conflict) {
+	if this == nil {
+		return
+	}
+	n := len(*ancestors)
+	if this.value != nil {
+		k := append([]byte(nil), key...)
+		for _, a := range *ancestors {
+			*result = append(*result, Conflict{a, k})
+		}
+		*ancestors = append(*ancestors, k)
+	}
+	for _, child := range this.sortedChildren() {
+		child.walkConflicts(append(key, child.prefix...), ancestors, result)
+	}
+	*ancestors = (*ancestors)[:n]
+}
+
+// PrefixChains returns every maximal chain of at least two keys where each key is a strict prefix of the next,
+// ordered by their last keys. A key which is a prefix of several others starts several chains.
+func (this *Trie) PrefixChains() [][][]byte {
+	result := [][][]byte{}
+	ancestors := [][]byte{}
+	this.walkChains(nil, &ancestors, &result)
+	return result
+}
+
+// walkChains collects the chains ending in the subtree of this node, where ancestors are the keys above it,
+// and reports whether the subtree holds a value.
+func (this *Trie) walkChains(key []byte, ancestors *[][]byte, result *[][][]byte) bool {
+	if this == nil {
+		return false
+	}
+	n := len(*ancestors)
+	if this.value != nil {
+		*ancestors = append(*ancestors, append([]byte(nil), key...))
+	}
+	below := false
+	for _, child := range this.sortedChildren() {
+		if child.walkChains(append(key, child.prefix...), ancestors, result) {
+			below = true
+		}
+	}
+	if this.value != nil && !below && len(*ancestors) > 1 {
+		*result = append(*result, append([][]byte(nil), *ancestors...))
+	}
+	*ancestors = (*ancestors)[:n]
+	return below || this.value != nil
+}
+
+// Values returns the values of all keys in lexicographic order of keys.
+func (this *Trie) Values() []Value {
+	result := make([]Value, 0, this.Len())
+	this.Walk(func(key []byte, value Value) bool {
+		result = append(result, value)
+		return true
+	})
+	return result
+}
+
+// Keys returns all keys in lexicographic order. Each key is a copy which the caller may modify.
+func (this *Trie) Keys() [][]byte {
+	result := make([][]byte, 0, this.Len())
+	this.Walk(func(key []byte, value Value) bool {
+		result = append(result, copyBytes(key))
+		return true
+	})
+	return result
+}
+
+// Same as Keys but returns strings.
+func (this *Trie) KeysString() []string {
+	result := make([]string, 0, this.Len())
+	this.Walk(func(key []byte, value Value) bool {
+		result = append(result, string(key))
+		return true
+	})
+	return result
+}
+
+// Partition returns the keys grouped by their first bytes, each group in lexicographic order. The empty key,
+// which has no first byte, is left out.
+func (this *Trie) Partition() map[byte][][]byte {
+	result := map[byte][][]byte{}
+	if this == nil {
+		return result
+	}
+	less := this.order()
+	for b, child := range this.children {
+		keys := [][]byte{}
+		child.walk(copyBytes(child.prefix), less, func(key []byte, value Value) bool {
+			keys = append(keys, copyBytes(key))
+			return true
+		})
+		result[b] = keys
+	}
+	return result
+}
+
+// TrieStats is the type of returned value of Trie's Stats function.
+type TrieStats struct {
+	NodeCount     int // number of nodes including the root
+	LeafCount     int // number of nodes without children
+	MaxDepth      int // number of edges on the longest path from the root
+	TotalKeyBytes int // sum of the lengths of all keys
+}
+
+// Stats walks the Trie and returns statistics of its structure.
+func (this *Trie) Stats() TrieStats {
+	var stats TrieStats
+	if this != nil {
+		this.stats(&stats, 0, 0)
+	}
+	return stats
+}
+
+func (this *Trie) stats(stats *TrieStats, depth, keyLen int) {
+	stats.NodeCount++
+	if len(this.children) == 0 {
+		stats.LeafCount++
+	}
+	if depth > stats.MaxDepth {
+		stats.MaxDepth = depth
+	}
+	if this.value != nil {
+		stats.TotalKeyBytes += keyLen
+	}
+	for _, child := range this.children {
+		child.stats(stats, depth+1, keyLen+len(child.prefix))
+	}
+}
+
+// Approximate sizes of a map with byte keys and pointer values, as in the children of a node, and of an entry
+// of the intern table, whose string key and slice value both refer to the shared prefix.
+const (
+	mapHeaderBytes   = 48
+	mapEntryBytes    = 16
+	internEntryBytes = 48
+)
+
+// MemoryBytes estimates the number of bytes used by the Trie: its nodes, their prefixes, children maps and
+// the boxes holding the values, but not the memory referenced by the values. It grows with the number and
+// length of keys, without being exact.
+func (this *Trie) MemoryBytes() int {
+	if this == nil {
+		return 0
+	}
+	size := int(unsafe.Sizeof(*this)) + cap(this.prefix)
+	if this.opts != nil && this.opts.intern != nil {
+		size += mapHeaderBytes + internEntryBytes*len(this.opts.intern)
+	}
+	if this.value != nil {
+		size += int(unsafe.Sizeof(*this.value))
+	}
+	if this.children != nil {
+		size += mapHeaderBytes + mapEntryBytes*len(this.children)
+	}
+	for _, child := range this.children {
+		size += child.MemoryBytes()
+	}
+	return size
+}
+
+// ByteHistogram returns how many times each byte occurs in all keys together.
+func (this *Trie) ByteHistogram() [256]int {
+	var histogram [256]int
+	if this != nil {
+		this.countBytes(&histogram)
+	}
+	return histogram
+}
+
+// countBytes adds the bytes of the subtree of this node to the histogram, where the prefix of each node occurs
+// in every key below it, and returns the number of keys in the subtree.
+func (this *Trie) countBytes(histogram *[256]int) int {
+	count := 0
+	if this.value != nil {
+		count++
+	}
+	for _, child := range this.children {
+		count += child.countBytes(histogram)
+	}
+	for _, b := range this.prefix {
+		histogram[b] += count
+	}
+	return count
+}
+
+// LongestKeyLen returns the length of the longest key, or 0 for an empty Trie.
+func (this *Trie) LongestKeyLen() int {
+	return len(this.LongestKey())
+}
+
+// LongestKey returns the longest key, the first in lexicographic order of the longest ones. For an empty Trie,
+// return nil, which the empty key is told apart from by being an empty non-nil slice.
+func (this *Trie) LongestKey() []byte {
+	var longest []byte
+	this.Walk(func(key []byte, value Value) bool {
+		if longest == nil || len(key) > len(longest) {
+			longest = copyBytes(key)
+		}
+		return true
+	})
+	return longest
+}
+
+// LongestCommonKeyPrefix returns the longest prefix shared by all keys. For an empty Trie, return an empty slice.
+func (this *Trie) LongestCommonKeyPrefix() []byte {
+	result := []byte{}
+	for this != nil && this.value == nil && len(this.children) == 1 {
+		for _, child := range this.children {
+			result = append(result, child.prefix...)
+			this = child
+		}
+	}
+	return result
+}
+
+// MinKey returns the smallest key in lexicographic order and its value. For an empty Trie, return nil, nil, false.
+func (this *Trie) MinKey() (key []byte, value Value, found bool) {
+	if this == nil {
+		return nil, nil, false
+	}
+	for this.value == nil {
+		if len(this.children) == 0 {
+			return nil, nil, false
+		}
+		var min *Trie
+		for _, child := range this.children {
+			if min == nil || child.prefix[0] < min.prefix[0] {
+				min = child
+			}
+		}
+		key = append(key, min.prefix...)
+		this = min
+	}
+	return key, *this.value, true
+}
+
+// MaxKey returns the largest key in lexicographic order and its value. For an empty Trie, return nil, nil, false.
+func (this *Trie) MaxKey() (key []byte, value Value, found bool) {
+	if this == nil {
+		return nil, nil, false
+	}
+	var path []byte
+	for {
+		if this.value != nil {
+			key, value, found = path, *this.value, true
+		}
+		if len(this.children) == 0 {
+			return key, value, found
+		}
+		var max *Trie
+		for _, child := range this.children {
+			if max == nil || child.prefix[0] > max.prefix[0] {
+				max = child
+			}
+		}
+		path = append(path, max.prefix...)
+		this = max
+	}
 }
 
-// Get the value associated with the key. If no such key was added, return nil, false.
-func (this *Trie) GetBytes(key []byte) (value Value, found bool) {
-	return this.get(&inputBytes{key})
+// Return all keys starting with the prefix in lexicographic order. If no key has the prefix, return an empty list.
+func (this *Trie) KeysWithPrefixBytes(prefix []byte) [][]byte {
+	return this.keysWithPrefix(&inputBytes{prefix})
 }
 
-// Same as GetBytes but works for string.
-func (this *Trie) GetString(key string) (value Value, found bool) {
-	return this.get(&inputString{key})
+// Same as KeysWithPrefixBytes but works for string.
+func (this *Trie) KeysWithPrefixString(prefix string) [][]byte {
+	return this.keysWithPrefix(&inputString{prefix})
 }
 
-func (this *Trie) get(in input) (value Value, found bool) {
-	r := this.findNode(in, exactMatch)
-	if len(r) == 0 {
-		return Value(nil), false
+func (this *Trie) keysWithPrefix(prefix input) [][]byte {
+	result := [][]byte{}
+	this.walkPrefix(prefix, func(key []byte, value Value) bool {
+		result = append(result, append([]byte(nil), key...))
+		return true
+	})
+	return result
+}
+
+// WalkPrefixBytes is like Walk but only visits the keys starting with the prefix.
+func (this *Trie) WalkPrefixBytes(prefix []byte, fn func(key []byte, value Value) bool) {
+	this.walkPrefix(&inputBytes{prefix}, fn)
+}
+
+// Same as WalkPrefixBytes but works for string.
+func (this *Trie) WalkPrefixString(prefix string, fn func(key []byte, value Value) bool) {
+	this.walkPrefix(&inputString{prefix}, fn)
+}
+
+func (this *Trie) walkPrefix(prefix input, fn func(key []byte, value Value) bool) {
+	node, path := this.seek(prefix)
+	if node != nil {
+		node.walk(path, this.order(), fn)
 	}
-	return *r[0].trie.value, true
 }
 
-// PrefixMatch is the type of returned value of Trie's prefix matching functions.
-type PrefixMatch struct {
-	PrefixLength int
-	Value        Value
+// TopKeysWithPrefix returns at most n keys starting with the prefix and their values, ranked by less on the
+// values so that the first result is the least. Keys with equally ranked values are in lexicographic order.
+// PrefixLength of each result is the length of its key.
+func (this *Trie) TopKeysWithPrefix(prefix []byte, n int, less func(a, b Value) bool) []PrefixMatchKey {
+	top := &topKeys{matches: []PrefixMatchKey{}, less: less}
+	node, path := this.seek(&inputBytes{prefix})
+	if node == nil || n <= 0 {
+		return top.matches
+	}
+	node.walk(path, nil, func(key []byte, value Value) bool {
+		if len(top.matches) < n {
+			heap.Push(top, PrefixMatchKey{len(key), append([]byte(nil), key...), value})
+		} else if less(value, top.matches[0].Value) {
+			top.matches[0] = PrefixMatchKey{len(key), append([]byte(nil), key...), value}
+			heap.Fix(top, 0)
+		}
+		return true
+	})
+	sort.Slice(top.matches, func(i, j int) bool {
+		a, b := top.matches[i], top.matches[j]
+		if less(a.Value, b.Value) || less(b.Value, a.Value) {
+			return less(a.Value, b.Value)
+		}
+		return bytes.Compare(a.Key, b.Key) < 0
+	})
+	return top.matches
 }
 
-// Match the shortest prefix and associated value. If no prefix is found, return {nil, nil}, false.
-func (this *Trie) MatchShortestPrefixBytes(input []byte) (match PrefixMatch, found bool) {
-	return this.matchPrefix(&inputBytes{input}, shortestPrefix)
+// topKeys is a heap of matches with the worst ranked one on the top, implementing heap.Interface.
+type topKeys struct {
+	matches []PrefixMatchKey
+	less    func(a, b Value) bool
 }
 
-// Same as MatchShortestPrefixBytes but works for string.
-func (this *Trie) MatchShortestPrefixString(input string) (match PrefixMatch, found bool) {
-	return this.matchPrefix(&inputString{input}, shortestPrefix)
+func (h *topKeys) Len() int {
+	return len(h.matches)
 }
 
-// Match the longest prefix and associated value. If no prefix is found, return {nil, nil}, false.
-func (this *Trie) MatchLongestPrefixBytes(input []byte) (match PrefixMatch, found bool) {
-	return this.matchPrefix(&inputBytes{input}, longestPrefix)
+func (h *topKeys) Less(i, j int) bool {
+	return h.less(h.matches[j].Value, h.matches[i].Value)
 }
 
-// Same as MatchLongestPrefixBytes but works for string.
-func (this *Trie) MatchLongestPrefixString(input string) (match PrefixMatch, found bool) {
-	return this.matchPrefix(&inputString{input}, longestPrefix)
+func (h *topKeys) Swap(i, j int) {
+	h.matches[i], h.matches[j] = h.matches[j], h.matches[i]
 }
 
-func (this *Trie) matchPrefix(in input, mode findNodeMode) (match PrefixMatch, found bool) {
-	r := this.findNode(in, mode)
-	if len(r) == 0 {
-		return PrefixMatch{}, false
+func (h *topKeys) Push(x interface{}) {
+	h.matches = append(h.matches, x.(PrefixMatchKey))
+}
+
+func (h *topKeys) Pop() interface{} {
+	last := h.matches[len(h.matches)-1]
+	h.matches = h.matches[:len(h.matches)-1]
+	return last
+}
+
+// Report whether any key starts with the prefix. Unlike ContainsBytes, the prefix itself needn't be a key.
+func (this *Trie) HasPrefixBytes(prefix []byte) bool {
+	node, _ := this.seek(&inputBytes{prefix})
+	return node != nil
+}
+
+// Same as HasPrefixBytes but works for string.
+func (this *Trie) HasPrefixString(prefix string) bool {
+	node, _ := this.seek(&inputString{prefix})
+	return node != nil
+}
+
+// Return the bytes in ascending order which can follow the prefix on the way to a key, that is the next byte of
+// the edge if the prefix ends inside one, or the first bytes of the children otherwise. If no key has the prefix,
+// or the prefix is a key without longer keys, return an empty list.
+func (this *Trie) NextBytesBytes(prefix []byte) []byte {
+	return this.nextBytes(&inputBytes{prefix})
+}
+
+// Same as NextBytesBytes but works for string.
+func (this *Trie) NextBytesString(prefix string) []byte {
+	return this.nextBytes(&inputString{prefix})
+}
+
+func (this *Trie) nextBytes(prefix input) []byte {
+	n := prefix.len()
+	node, path := this.seek(prefix)
+	if node == nil {
+		return []byte{}
 	}
-	return PrefixMatch{
-		PrefixLength: r[0].prefixLength,
-		Value:        *r[0].trie.value,
-	}, true
+	if len(path) > n {
+		return []byte{path[n]}
+	}
+	result := make([]byte, 0, len(node.children))
+	for _, child := range node.sortedChildren() {
+		result = append(result, child.prefix[0])
+	}
+	return result
 }
 
-// Match all possible prefixes and associated values as a list. If no prefix is found, return an empty list.
-func (this *Trie) MatchAllPrefixesBytes(in []byte) []PrefixMatch {
-	return this.matchAllPrefixes(&inputBytes{in})
+// Return the number of leading bytes of the input which follow a path in the trie, whether or not a key ends there.
+func (this *Trie) CommonPrefixLenBytes(input []byte) int {
+	return this.commonPrefixLen(&inputBytes{input})
 }
 
-// Same as MatchAllPrefixesBytes but works for string input.
-func (this *Trie) MatchAllPrefixesString(in string) []PrefixMatch {
-	return this.matchAllPrefixes(&inputString{in})
+// Same as CommonPrefixLenBytes but works for string.
+func (this *Trie) CommonPrefixLenString(input string) int {
+	return this.commonPrefixLen(&inputString{input})
 }
 
-func (this *Trie) matchAllPrefixes(in input) []PrefixMatch {
-	r := this.findNode(in, allPrefixex)
-	result := make([]PrefixMatch, len(r))
-	for i, v := range r {
-		result[i].PrefixLength = v.prefixLength
-		result[i].Value = *v.trie.value
+func (this *Trie) commonPrefixLen(in input) int {
+	if this == nil {
+		return 0
+	}
+	in = this.adapt(in)
+	length := 0
+	for !in.end() {
+		child, has := this.children[in.char()]
+		if !has {
+			break
+		}
+		if !in.hasPrefix(child.prefix) {
+			// The input diverges inside the edge, after the first byte which selected it.
+			n := 1
+			for n < len(child.prefix) && n < in.len() && in.at(n) == child.prefix[n] {
+				n++
+			}
+			return length + n
+		}
+		in.advance(len(child.prefix))
+		length += len(child.prefix)
+		this = child
+	}
+	return length
+}
+
+// Return the number of keys starting with the prefix.
+func (this *Trie) CountPrefixBytes(prefix []byte) int {
+	return this.countPrefix(&inputBytes{prefix})
+}
+
+// Same as CountPrefixBytes but works for string.
+func (this *Trie) CountPrefixString(prefix string) int {
+	return this.countPrefix(&inputString{prefix})
+}
+
+func (this *Trie) countPrefix(prefix input) int {
+	node, _ := this.seek(prefix)
+	if node == nil {
+		return 0
+	}
+	return node.countValues()
+}
+
+// Return all keys matching the pattern in lexicographic order, where the wildcard byte in the pattern matches
+// any single byte and other bytes match themselves.
+func (this *Trie) MatchWildcardBytes(pattern []byte, wildcard byte) [][]byte {
+	if this.foldsCase() {
+		// The wildcard itself is kept as is, so that a letter wildcard doesn't turn the other case into one.
+		folded := make([]byte, len(pattern))
+		for i, c := range pattern {
+			if c != wildcard {
+				c = lowerASCII(c)
+			}
+			folded[i] = c
+		}
+		pattern = folded
+	}
+	result := [][]byte{}
+	if this != nil {
+		this.matchWildcard(nil, pattern, wildcard, &result)
+	}
+	return result
+}
+
+// Same as MatchWildcardBytes but works for string.
+func (this *Trie) MatchWildcardString(pattern string, wildcard byte) [][]byte {
+	return this.MatchWildcardBytes([]byte(pattern), wildcard)
+}
+
+func (this *Trie) matchWildcard(path, pattern []byte, wildcard byte, result *[][]byte) {
+	if len(pattern) == 0 {
+		if this.value != nil {
+			*result = append(*result, append([]byte(nil), path...))
+		}
+		return
+	}
+	children := []*Trie{}
+	if pattern[0] == wildcard {
+		children = this.sortedChildren()
+	} else if child, has := this.children[pattern[0]]; has {
+		children = append(children, child)
+	}
+	for _, child := range children {
+		if wildcardHasPrefix(pattern, child.prefix, wildcard) {
+			child.matchWildcard(append(path, child.prefix...), pattern[len(child.prefix):], wildcard, result)
+		}
+	}
+}
+
+// wildcardHasPrefix reports whether the pattern begins with a match of the prefix.
+func wildcardHasPrefix(pattern, prefix []byte, wildcard byte) bool {
+	if len(pattern) < len(prefix) {
+		return false
+	}
+	for i, c := range prefix {
+		if pattern[i] != c && pattern[i] != wildcard {
+			return false
+		}
+	}
+	return true
+}
+
+// Return all keys matching the glob pattern in lexicographic order, where '*' in the pattern matches any run of
+// bytes including an empty one, '?' matches any single byte and other bytes match themselves.
+func (this *Trie) GlobMatchBytes(pattern []byte) [][]byte {
+	if this.foldsCase() {
+		pattern = toLowerASCII(pattern)
+	}
+	result := [][]byte{}
+	if this != nil {
+		this.matchGlob(nil, pattern, globClosure(pattern, []int{0}), &result)
+	}
+	return result
+}
+
+// Same as GlobMatchBytes but works for string.
+func (this *Trie) GlobMatchString(pattern string) [][]byte {
+	return this.GlobMatchBytes([]byte(pattern))
+}
+
+// matchGlob collects the keys matching the pattern in the subtree of this node, where states are the positions
+// in the pattern reached by the path to this node.
+func (this *Trie) matchGlob(path, pattern []byte, states []int, result *[][]byte) {
+	if this.value != nil && states[len(states)-1] == len(pattern) {
+		*result = append(*result, append([]byte(nil), path...))
+	}
+	for _, child := range this.sortedChildren() {
+		next := states
+		for _, c := range child.prefix {
+			if next = globStep(pattern, next, c); len(next) == 0 {
+				break
+			}
+		}
+		if len(next) != 0 {
+			child.matchGlob(append(path, child.prefix...), pattern, next, result)
+		}
+	}
+}
+
+// globStep returns the positions in the pattern reached from the states by matching the byte c.
+func globStep(pattern []byte, states []int, c byte) []int {
+	next := []int{}
+	for _, p := range states {
+		switch {
+		case p == len(pattern):
+		case pattern[p] == '*':
+			next = append(next, p)
+		case pattern[p] == '?' || pattern[p] == c:
+			next = append(next, p+1)
+		}
+	}
+	return globClosure(pattern, next)
+}
+
+// globClosure adds the positions following stars to the states, which may then be skipped, and returns them in
+// increasing order without duplicates.
+func globClosure(pattern []byte, states []int) []int {
+	reached := make([]bool, len(pattern)+1)
+	for _, p := range states {
+		reached[p] = true
+	}
+	result := []int{}
+	for p := range reached {
+		if reached[p] {
+			result = append(result, p)
+			if p < len(pattern) && pattern[p] == '*' {
+				reached[p+1] = true
+			}
+		}
+	}
+	return result
+}
+
+// FuzzyResult is the type of returned value of Trie's FuzzyMatch functions.
+type FuzzyResult struct {
+	Key      []byte
+	Value    Value
+	Distance int
+}
+
+// Return all keys within the Levenshtein distance maxDist of the query in lexicographic order, together with
+// their values and distances. Branches which can't get within maxDist are pruned.
+func (this *Trie) FuzzyMatchBytes(query []byte, maxDist int) []FuzzyResult {
+	if this.foldsCase() {
+		query = toLowerASCII(query)
+	}
+	result := []FuzzyResult{}
+	row := make([]int, len(query)+1)
+	for i := range row {
+		row[i] = i
+	}
+	if this != nil {
+		this.fuzzyMatch(nil, query, row, maxDist, &result)
 	}
 	return result
 }
 
+// Same as FuzzyMatchBytes but works for string.
+func (this *Trie) FuzzyMatchString(query string, maxDist int) []FuzzyResult {
+	return this.FuzzyMatchBytes([]byte(query), maxDist)
+}
+
+// fuzzyMatch collects the matches in the subtree of this node, where row holds the distances
+// between the path of this node and every prefix of the query.
+func (this *Trie) fuzzyMatch(path, query []byte, row []int, maxDist int, result *[]FuzzyResult) {
+	if this.value != nil && row[len(query)] <= maxDist {
+		*result = append(*result, FuzzyResult{append([]byte(nil), path...), *this.value, row[len(query)]})
+	}
+	for _, child := range this.sortedChildren() {
+		childRow := row
+		for _, c := range child.prefix {
+			childRow = levenshteinRow(childRow, query, c)
+		}
+		if minOf(childRow) <= maxDist {
+			child.fuzzyMatch(append(path, child.prefix...), query, childRow, maxDist, result)
+		}
+	}
+}
+
+// levenshteinRow returns the distances after appending c to the path whose distances are prev.
+func levenshteinRow(prev []int, query []byte, c byte) []int {
+	row := make([]int, len(prev))
+	row[0] = prev[0] + 1
+	for i := 1; i < len(row); i++ {
+		cost := 1
+		if query[i-1] == c {
+			cost = 0
+		}
+		row[i] = min(row[i-1]+1, prev[i]+1, prev[i-1]+cost)
+	}
+	return row
+}
+
+func minOf(row []int) int {
+	m := row[0]
+	for _, d := range row[1:] {
+		m = min(m, d)
+	}
+	return m
+}
+
+// SubTrieBytes returns the Trie of the keys starting with the prefix, with the prefix removed from the keys.
+// If no key starts with the prefix, return nil, false. The returned Trie shares nodes with this one, so it must
+// be treated as read-only and it's invalidated by modifications of this Trie. Use Clone for an independent copy.
+func (this *Trie) SubTrieBytes(prefix []byte) (*Trie, bool) {
+	return this.subTrie(&inputBytes{prefix})
+}
+
+// Same as SubTrieBytes but works for string.
+func (this *Trie) SubTrieString(prefix string) (*Trie, bool) {
+	return this.subTrie(&inputString{prefix})
+}
+
+func (this *Trie) subTrie(prefix input) (*Trie, bool) {
+	n := prefix.len()
+	node, path := this.seek(prefix)
+	if node == nil {
+		return nil, false
+	}
+	sub := &Trie{opts: this.opts}
+	if rest := path[n:]; len(rest) != 0 {
+		// The prefix ends in the middle of the node's prefix, so the rest of it becomes a synthetic child.
+		sub.children = map[byte]*Trie{rest[0]: {value: node.value, prefix: rest, children: node.children}}
+	} else {
+		sub.value, sub.children = node.value, node.children
+	}
+	sub.size = sub.countValues()
+	return sub, true
+}
+
+// seek returns the topmost node whose path starts with the prefix, together with that path.
+// The prefix may end in the middle of the node's own prefix. If no such node exists, return nil.
+func (this *Trie) seek(prefix input) (node *Trie, path []byte) {
+	if this == nil {
+		return nil, nil
+	}
+	prefix = this.adapt(prefix)
+	for !prefix.end() {
+		child, has := this.children[prefix.char()]
+		if !has {
+			return nil, nil
+		}
+		path = append(path, child.prefix...)
+		if !prefix.hasPrefix(child.prefix) {
+			if prefix.prefixOf(child.prefix) {
+				return child, path
+			}
+			return nil, nil
+		}
+		prefix.advance(len(child.prefix))
+		this = child
+	}
+	if this.value == nil && len(this.children) == 0 {
+		// Only the root of an empty trie has neither value nor children.
+		return nil, nil
+	}
+	return this, path
+}
+
+// String renders the structure of the Trie, one node per line in sorted order. Each line holds the quoted prefix
+// of the node indented by its depth, followed by the value if the node has one.
+func (this *Trie) String() string {
+	if this == nil {
+		this = NewTrie()
+	}
+	var buf bytes.Buffer
+	this.format(&buf, 0)
+	return buf.String()
+}
+
+func (this *Trie) format(buf *bytes.Buffer, depth int) {
+	fmt.Fprintf(buf, "%s%q", strings.Repeat("  ", depth), this.prefix)
+	if this.value != nil {
+		fmt.Fprintf(buf, ": %v", *this.value)
+	}
+	buf.WriteByte('\n')
+	for _, child := range this.sortedChildren() {
+		child.format(buf, depth+1)
+	}
+}
+
+// sortedChildren returns the children of this node in ascending order of their first byte.
+func (this *Trie) sortedChildren() []*Trie {
+	return this.orderedChildren(nil)
+}
+
+// orderedChildren returns the children of this node ordered by less on their first byte,
+// or in ascending order if less is nil.
+func (this *Trie) orderedChildren(less func(a, b byte) bool) []*Trie {
+	children := make([]*Trie, 0, len(this.children))
+	for _, child := range this.children {
+		children = append(children, child)
+	}
+	if less == nil {
+		sort.Slice(children, func(i, j int) bool {
+			return children[i].prefix[0] < children[j].prefix[0]
+		})
+	} else {
+		sort.Slice(children, func(i, j int) bool {
+			return less(children[i].prefix[0], children[j].prefix[0])
+		})
+	}
+	return children
+}
+
 func (this *Trie) createNode(key []byte) *Trie {
+	this.checkMutable()
+	if this.foldsCase() {
+		key = toLowerASCII(key)
+	}
+	root := this
 	for len(key) != 0 {
 		firstByte := key[0]
 		child, has := this.children[firstByte]
 		if !has {
-			child = &Trie{prefix: make([]byte, len(key))}
-			copy(child.prefix, key)
+			child = &Trie{prefix: root.newPrefix(key), gen: root.gen}
 			if this.children == nil {
 				this.children = make(map[byte]*Trie)
 			}
 			this.children[firstByte] = child
 			return child
 		}
+		child = root.own(this, child)
 		commonPrefixLen := longestCommonPrefix(child.prefix, key)
 		if commonPrefixLen < len(child.prefix) {
+			// Both parts of the split prefix get their own memory, so no two nodes share a prefix array
+			// unless the trie interns prefixes, in which case shared arrays are never modified.
 			newChild := &Trie{
-				prefix:   child.prefix[:commonPrefixLen],
+				prefix:   root.newPrefix(child.prefix[:commonPrefixLen]),
 				children: map[byte]*Trie{child.prefix[commonPrefixLen]: child},
+				gen:      root.gen,
 			}
-			child.prefix = child.prefix[commonPrefixLen:]
+			child.prefix = root.newPrefix(child.prefix[commonPrefixLen:])
 			this.children[firstByte] = newChild
 			this = newChild
 			key = key[commonPrefixLen:]
@@ -132,6 +2248,49 @@ func (this *Trie) createNode(key []byte) *Trie {
 	return this
 }
 
+// newPrefix returns a copy of b to be used as a node prefix, shared with other nodes if the trie interns prefixes.
+func (this *Trie) newPrefix(b []byte) []byte {
+	if this.opts == nil || this.opts.intern == nil {
+		return copyBytes(b)
+	}
+	if shared, has := this.opts.intern[string(b)]; has {
+		return shared
+	}
+	this.trimIntern()
+	shared := copyBytes(b)
+	this.intern(shared)
+	return shared
+}
+
+// intern adds the prefix to the intern table of the root this. The key of the entry refers to the prefix
+// itself instead of a copy, which is safe as interned prefixes are never modified.
+func (this *Trie) intern(prefix []byte) {
+	this.opts.intern[unsafe.String(unsafe.SliceData(prefix), len(prefix))] = prefix
+}
+
+// trimIntern rebuilds the intern table of the root this, if the Trie interns prefixes and most of them
+// belong to deleted keys, as a Trie has at most 2*size+1 nodes.
+func (this *Trie) trimIntern() {
+	if this.opts != nil && this.opts.intern != nil && len(this.opts.intern) > 2*(2*this.size+1) {
+		this.rebuildIntern()
+	}
+}
+
+// rebuildIntern replaces the intern table of the root this by one holding only the prefixes of its nodes.
+func (this *Trie) rebuildIntern() {
+	this.opts.intern = make(map[string][]byte, len(this.opts.intern)/2)
+	var add func(node *Trie)
+	add = func(node *Trie) {
+		for _, child := range node.children {
+			if _, has := this.opts.intern[string(child.prefix)]; !has {
+				this.intern(child.prefix)
+			}
+			add(child)
+		}
+	}
+	add(this)
+}
+
 type findNodeMode int
 
 const (
@@ -150,7 +2309,10 @@ type input interface {
 	end() bool
 	char() byte
 	hasPrefix([]byte) bool
+	prefixOf([]byte) bool
 	advance(int)
+	len() int
+	at(int) byte
 }
 
 type inputBytes struct {
@@ -169,10 +2331,22 @@ func (i *inputBytes) hasPrefix(prefix []byte) bool {
 	return bytes.HasPrefix(i.b, prefix)
 }
 
+func (i *inputBytes) prefixOf(b []byte) bool {
+	return bytes.HasPrefix(b, i.b)
+}
+
 func (i *inputBytes) advance(n int) {
 	i.b = i.b[n:]
 }
 
+func (i *inputBytes) len() int {
+	return len(i.b)
+}
+
+func (i *inputBytes) at(n int) byte {
+	return i.b[n]
+}
+
 type inputString struct {
 	s string
 }
@@ -189,15 +2363,73 @@ func (i *inputString) hasPrefix(prefix []byte) bool {
 	return strings.HasPrefix(i.s, string(prefix))
 }
 
+func (i *inputString) prefixOf(b []byte) bool {
+	return len(i.s) <= len(b) && string(b[:len(i.s)]) == i.s
+}
+
 func (i *inputString) advance(n int) {
 	i.s = i.s[n:]
 }
 
+func (i *inputString) len() int {
+	return len(i.s)
+}
+
+func (i *inputString) at(n int) byte {
+	return i.s[n]
+}
+
+// inputFolded maps ASCII upper case letters of the wrapped input to lower case.
+type inputFolded struct {
+	input
+}
+
+func (i *inputFolded) char() byte {
+	return lowerASCII(i.input.char())
+}
+
+func (i *inputFolded) hasPrefix(prefix []byte) bool {
+	if i.len() < len(prefix) {
+		return false
+	}
+	for n, c := range prefix {
+		if i.at(n) != c {
+			return false
+		}
+	}
+	return true
+}
+
+func (i *inputFolded) prefixOf(b []byte) bool {
+	if i.len() > len(b) {
+		return false
+	}
+	for n := 0; n < i.len(); n++ {
+		if i.at(n) != b[n] {
+			return false
+		}
+	}
+	return true
+}
+
+func (i *inputFolded) at(n int) byte {
+	return lowerASCII(i.input.at(n))
+}
+
 func (this *Trie) findNode(key input, mode findNodeMode) []*findNodeResult {
+	key = this.adapt(key)
 	result := []*findNodeResult{}
+	if this == nil {
+		return result
+	}
 	length := 0
 	for !key.end() {
-		if this.value != nil && (mode == shortestPrefix || mode == allPrefixex) {
+		if this.value != nil && mode != exactMatch {
+			if mode == longestPrefix {
+				// Only the longest prefix so far is kept, as the path may continue through nodes without
+				// value and fail before reaching a longer prefix.
+				result = result[:0]
+			}
 			result = append(result, &findNodeResult{length, this})
 			if mode == shortestPrefix {
 				return result
@@ -207,21 +2439,30 @@ func (this *Trie) findNode(key input, mode findNodeMode) []*findNodeResult {
 		child, has := this.children[firstByte]
 		has = has && key.hasPrefix(child.prefix)
 		if !has {
-			if this.value != nil && mode == longestPrefix {
-				result = append(result, &findNodeResult{length, this})
-			}
 			return result
 		}
 		key.advance(len(child.prefix))
 		length += len(child.prefix)
 		this = child
 	}
+	// The whole key is consumed, so the last node is a match in every mode, including
+	// the full length match following shorter prefixes in allPrefixex mode.
 	if this.value != nil {
+		if mode == longestPrefix {
+			result = result[:0]
+		}
 		result = append(result, &findNodeResult{length, this})
 	}
 	return result
 }
 
+// copyBytes returns a copy of b in its own memory.
+func copyBytes(b []byte) []byte {
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
+
 func longestCommonPrefix(a, b []byte) int {
 	minLen := len(a)
 	if len(b) < minLen {
@@ -234,3 +2475,18 @@ func longestCommonPrefix(a, b []byte) int {
 	}
 	return minLen
 }
+
+func lowerASCII(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+func toLowerASCII(key []byte) []byte {
+	lower := make([]byte, len(key))
+	for i, c := range key {
+		lower[i] = lowerASCII(c)
+	}
+	return lower
+}
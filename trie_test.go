@@ -1,6 +1,13 @@
 package trie
 
 import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -25,6 +32,13 @@ var nonKeys = []string{
 }
 var content = "abcdefghijklm"
 var noPrefixContent = "abcdefXX"
+var completions = []string{
+	"abcdefg",
+	"abcdefgXXX",
+	"abcdefghi",
+	"abcdefghijk",
+	"abcdefgk",
+}
 var prefixes = []string{
 	"abcdefg",
 	"abcdefghi",
@@ -83,6 +97,55 @@ func TestTrieGetString(t *testing.T) {
 	}
 }
 
+func TestTrieNilValue(t *testing.T) {
+	trie := createTestTrie()
+	trie.Add([]byte("nil"), nil)
+	v, ok := trie.GetBytes([]byte("nil"))
+	if !ok || v != nil {
+		t.Errorf("Wrong result %v, %v for nil value", v, ok)
+	}
+	v, ok = trie.GetBytes([]byte("missing"))
+	if ok || v != nil {
+		t.Errorf("Wrong result %v, %v for missing key", v, ok)
+	}
+	trie.Add([]byte(keys[0]), nil)
+	v, ok = trie.GetString(keys[0])
+	if !ok || v != nil {
+		t.Errorf("Wrong result %v, %v for value overridden by nil", v, ok)
+	}
+	if trie.Len() != len(keys)+1 {
+		t.Errorf("Wrong length %d, expected %d", trie.Len(), len(keys)+1)
+	}
+}
+
+func TestTrieContainsBytes(t *testing.T) {
+	trie := createTestTrie()
+	for _, k := range keys {
+		if !trie.ContainsBytes([]byte(k)) {
+			t.Errorf("Unable to find key %s", k)
+		}
+	}
+	for _, k := range nonKeys {
+		if trie.ContainsBytes([]byte(k)) {
+			t.Errorf("Unexpected key %s", k)
+		}
+	}
+}
+
+func TestTrieContainsString(t *testing.T) {
+	trie := createTestTrie()
+	for _, k := range keys {
+		if !trie.ContainsString(k) {
+			t.Errorf("Unable to find key %s", k)
+		}
+	}
+	for _, k := range nonKeys {
+		if trie.ContainsString(k) {
+			t.Errorf("Unexpected key %s", k)
+		}
+	}
+}
+
 func TestTrieMatchAllPrefixesBytes(t *testing.T) {
 	trie := createTestTrie()
 	r := trie.MatchAllPrefixesBytes([]byte(content))
@@ -117,6 +180,22 @@ func TestTrieMatchAllPrefixesString(t *testing.T) {
 	}
 }
 
+func TestTrieMatchAllPrefixesExactMatch(t *testing.T) {
+	trie := createTestTrie()
+	input := "abcdefghijk"
+	expected := []int{7, 9, 11}
+	for _, r := range [][]PrefixMatch{trie.MatchAllPrefixesBytes([]byte(input)), trie.MatchAllPrefixesString(input)} {
+		if len(r) != len(expected) {
+			t.Fatalf("Wrong prefixes %v, expected lengths %v", r, expected)
+		}
+		for i, n := range expected {
+			if r[i].PrefixLength != n || r[i].Value.(string) != input[:n] {
+				t.Errorf("Wrong prefix[%d] %v, expected length %d", i, r[i], n)
+			}
+		}
+	}
+}
+
 func TestTrieMatchShortestPrefixBytes(t *testing.T) {
 	trie := createTestTrie()
 	v, ok := trie.MatchShortestPrefixBytes([]byte(content))
@@ -181,6 +260,20 @@ func TestTrieMatchLongestPrefixString(t *testing.T) {
 	}
 }
 
+func TestTrieMatchLongestPrefixPastBranch(t *testing.T) {
+	trie := NewTrie()
+	for _, key := range []string{"a", "abcd", "abce"} {
+		trie.AddString(key, key)
+	}
+	// The descent passes "a", then fails below the valueless branch node "abc".
+	for _, in := range []string{"abcx", "abc"} {
+		v, ok := trie.MatchLongestPrefixString(in)
+		if !ok || v.PrefixLength != 1 || v.Value.(string) != "a" {
+			t.Errorf("Wrong longest prefix of %s: %v, %v", in, v, ok)
+		}
+	}
+}
+
 func TestNoPrefixContentBytes(t *testing.T) {
 	trie := createTestTrie()
 	r := trie.MatchAllPrefixesBytes([]byte(noPrefixContent))
@@ -212,3 +305,2860 @@ func TestNoPrefixContentString(t *testing.T) {
 		t.Errorf("Unexpected longest prefix found %v", v)
 	}
 }
+
+func TestTrieDelete(t *testing.T) {
+	trie := createTestTrie()
+	deleted := map[string]bool{"abcdefg": true, "abcdefghi": true, "abcdxyz": true}
+	for k := range deleted {
+		v, ok := trie.Delete([]byte(k))
+		if !ok {
+			t.Errorf("Unable to delete key %s", k)
+		}
+		if v.(string) != k {
+			t.Errorf("Wrong deleted value %v, expected %v", v, k)
+		}
+		v, ok = trie.Delete([]byte(k))
+		if ok || v != nil {
+			t.Errorf("Unexpected second delete of %s: %v, %v", k, v, ok)
+		}
+	}
+	for _, k := range keys {
+		v, ok := trie.GetBytes([]byte(k))
+		if deleted[k] {
+			if ok {
+				t.Errorf("Deleted key %s still found with value %v", k, v)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("Unable to find key %s", k)
+		} else if v.(string) != k {
+			t.Errorf("Wrong value %v, expected %v", v, k)
+		}
+	}
+	for _, k := range nonKeys {
+		if v, ok := trie.Delete([]byte(k)); ok {
+			t.Errorf("Unexpected delete of key %s, value %v", k, v)
+		}
+	}
+}
+
+func TestTrieDeleteString(t *testing.T) {
+	trie := createTestTrie()
+	for _, k := range keys {
+		v, ok := trie.DeleteString(k)
+		if !ok {
+			t.Errorf("Unable to delete key %s", k)
+		}
+		if v.(string) != k {
+			t.Errorf("Wrong deleted value %v, expected %v", v, k)
+		}
+	}
+	if len(trie.children) != 0 {
+		t.Errorf("Trie should have no children after deleting all keys, but %v", trie.children)
+	}
+	if trie.Len() != 0 {
+		t.Errorf("Wrong length %d after deleting all keys", trie.Len())
+	}
+}
+
+func TestTrieDeleteCompacts(t *testing.T) {
+	trie := NewTrie()
+	trie.Add([]byte("abcdefg"), 1)
+	trie.Add([]byte("abcdefghi"), 2)
+	trie.Add([]byte("abcdxyz"), 3)
+	trie.Delete([]byte("abcdxyz"))
+	trie.Delete([]byte("abcdefg"))
+	child := trie.children['a']
+	if string(child.prefix) != "abcdefghi" || len(child.children) != 0 {
+		t.Errorf("Trie not compacted after delete: prefix %s, children %v", child.prefix, child.children)
+	}
+	if v, ok := trie.GetString("abcdefghi"); !ok || v.(int) != 2 {
+		t.Errorf("Wrong value %v, %v after compaction", v, ok)
+	}
+}
+
+func TestTrieLen(t *testing.T) {
+	trie := NewTrie()
+	if trie.Len() != 0 {
+		t.Errorf("Wrong length %d of empty trie", trie.Len())
+	}
+	trie = createTestTrie()
+	if trie.Len() != len(keys) {
+		t.Errorf("Wrong length %d, expected %d", trie.Len(), len(keys))
+	}
+	trie.Add([]byte(keys[0]), "again")
+	if trie.Len() != len(keys) {
+		t.Errorf("Wrong length %d after adding existing key, expected %d", trie.Len(), len(keys))
+	}
+	trie.Delete([]byte(keys[0]))
+	trie.Delete([]byte(nonKeys[1]))
+	if trie.Len() != len(keys)-1 {
+		t.Errorf("Wrong length %d after delete, expected %d", trie.Len(), len(keys)-1)
+	}
+}
+
+func TestTrieWalk(t *testing.T) {
+	trie := createTestTrie()
+	sorted := append([]string{}, keys...)
+	sort.Strings(sorted)
+	walked := []string{}
+	trie.Walk(func(key []byte, value Value) bool {
+		if value.(string) != string(key) {
+			t.Errorf("Wrong value %v for key %s", value, key)
+		}
+		walked = append(walked, string(key))
+		return true
+	})
+	if len(walked) != len(sorted) {
+		t.Fatalf("Wrong walked keys %v vs. %v", walked, sorted)
+	}
+	for i, k := range sorted {
+		if walked[i] != k {
+			t.Errorf("Wrong key[%d] %s vs. %s", i, walked[i], k)
+		}
+	}
+}
+
+func TestTrieWalkStop(t *testing.T) {
+	trie := createTestTrie()
+	count := 0
+	trie.Walk(func(key []byte, value Value) bool {
+		count++
+		return count < 3
+	})
+	if count != 3 {
+		t.Errorf("Walk should stop after 3 keys, but visited %d", count)
+	}
+}
+
+func TestTrieWalkReverse(t *testing.T) {
+	trie := createTestTrie()
+	sorted := append([]string{}, keys...)
+	sort.Sort(sort.Reverse(sort.StringSlice(sorted)))
+	walked := []string{}
+	trie.WalkReverse(func(key []byte, value Value) bool {
+		walked = append(walked, string(key))
+		return true
+	})
+	if !reflect.DeepEqual(walked, sorted) {
+		t.Errorf("Wrong walked keys %v vs. %v", walked, sorted)
+	}
+	count := 0
+	trie.WalkReverse(func(key []byte, value Value) bool {
+		count++
+		return count < 3
+	})
+	if count != 3 {
+		t.Errorf("WalkReverse should stop after 3 keys, but visited %d", count)
+	}
+}
+
+func TestTrieWalkContext(t *testing.T) {
+	trie := createTestTrie()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	count := 0
+	err := trie.WalkContext(ctx, func(key []byte, value Value) bool {
+		count++
+		if count == 3 {
+			cancel()
+		}
+		return true
+	})
+	if err != context.Canceled || count != 3 {
+		t.Errorf("Wrong result %v after %d keys, expected to stop after 3", err, count)
+	}
+	count = 0
+	err = trie.WalkContext(context.Background(), func(key []byte, value Value) bool {
+		count++
+		return true
+	})
+	if err != nil || count != len(keys) {
+		t.Errorf("Wrong result %v after %d keys, expected %d", err, count, len(keys))
+	}
+}
+
+func TestTrieWalkNodes(t *testing.T) {
+	trie := createTestTrie()
+	type node struct {
+		hasValue   bool
+		childCount int
+	}
+	visited := map[string]node{}
+	paths := []string{}
+	trie.WalkNodes(func(path []byte, hasValue bool, childCount int) bool {
+		visited[string(path)] = node{hasValue, childCount}
+		paths = append(paths, string(path))
+		return true
+	})
+	if n := trie.Stats().NodeCount; len(paths) != n {
+		t.Errorf("Visited %d nodes %q, expected %d", len(paths), paths, n)
+	}
+	for path, expected := range map[string]node{"": {false, 1}, "ab": {false, 2}, "abcd": {false, 3}, "abcdefg": {true, 3}} {
+		if n, ok := visited[path]; !ok || n != expected {
+			t.Errorf("Wrong node %v, %v at %s, expected %v", n, ok, path, expected)
+		}
+	}
+	if !sort.StringsAreSorted(paths) {
+		t.Errorf("Nodes should be visited in order, but got %q", paths)
+	}
+	count := 0
+	trie.WalkNodes(func(path []byte, hasValue bool, childCount int) bool {
+		count++
+		return count < 2
+	})
+	if count != 2 {
+		t.Errorf("WalkNodes should stop after 2 nodes, but visited %d", count)
+	}
+}
+
+func TestTrieWalkPrefixBytes(t *testing.T) {
+	trie := createTestTrie()
+	walked := []string{}
+	trie.WalkPrefixBytes([]byte("abcdefg"), func(key []byte, value Value) bool {
+		if value != string(key) {
+			t.Errorf("Wrong value %v for key %s", value, key)
+		}
+		walked = append(walked, string(key))
+		return true
+	})
+	if !reflect.DeepEqual(walked, completions) {
+		t.Errorf("Wrong walked keys %v, expected %v", walked, completions)
+	}
+	walked = walked[:0]
+	trie.WalkPrefixBytes([]byte("abcde"), func(key []byte, value Value) bool {
+		walked = append(walked, string(key))
+		return len(walked) < 2
+	})
+	if !reflect.DeepEqual(walked, completions[:2]) {
+		t.Errorf("Wrong walked keys %v from the middle of an edge", walked)
+	}
+	trie.WalkPrefixBytes([]byte("abcdX"), func(key []byte, value Value) bool {
+		t.Errorf("Unexpected key %s", key)
+		return true
+	})
+}
+
+func TestTrieWalkPrefixString(t *testing.T) {
+	trie := createTestTrie()
+	count := 0
+	trie.WalkPrefixString("ab", func(key []byte, value Value) bool {
+		count++
+		return true
+	})
+	if count != len(keys) {
+		t.Errorf("Wrong number %d of walked keys, expected %d", count, len(keys))
+	}
+}
+
+func TestTrieKeysWithPrefixBytes(t *testing.T) {
+	trie := createTestTrie()
+	r := trie.KeysWithPrefixBytes([]byte("abcdefg"))
+	if len(r) != len(completions) {
+		t.Fatalf("Wrong keys %q vs. %q", r, completions)
+	}
+	for i, k := range completions {
+		if string(r[i]) != k {
+			t.Errorf("Wrong key[%d] %s vs. %s", i, r[i], k)
+		}
+	}
+	if r := trie.KeysWithPrefixBytes([]byte("abcdefX")); len(r) != 0 {
+		t.Errorf("Unexpected keys %q", r)
+	}
+	if r := trie.KeysWithPrefixBytes(nil); len(r) != len(keys) {
+		t.Errorf("Empty prefix should return all keys, but %q", r)
+	}
+}
+
+func TestTrieKeysWithPrefixString(t *testing.T) {
+	trie := createTestTrie()
+	// "abcde" ends in the middle of an edge.
+	r := trie.KeysWithPrefixString("abcde")
+	if len(r) != len(completions) {
+		t.Fatalf("Wrong keys %q vs. %q", r, completions)
+	}
+	for i, k := range completions {
+		if string(r[i]) != k {
+			t.Errorf("Wrong key[%d] %s vs. %s", i, r[i], k)
+		}
+	}
+	if r := trie.KeysWithPrefixString("abcdefghijkl"); len(r) != 0 {
+		t.Errorf("Unexpected keys %q", r)
+	}
+}
+
+func TestTrieClear(t *testing.T) {
+	trie := createTestTrie()
+	trie.Add(nil, "root")
+	trie.Clear()
+	if trie.Len() != 0 {
+		t.Errorf("Wrong length %d after clear", trie.Len())
+	}
+	trie.Walk(func(key []byte, value Value) bool {
+		t.Errorf("Unexpected key %s, value %v after clear", key, value)
+		return true
+	})
+	for _, k := range keys {
+		trie.Add([]byte(k), k)
+	}
+	for _, k := range keys {
+		if v, ok := trie.GetString(k); !ok || v.(string) != k {
+			t.Errorf("Wrong value %v, %v for key %s", v, ok, k)
+		}
+	}
+	if trie.Len() != len(keys) {
+		t.Errorf("Wrong length %d, expected %d", trie.Len(), len(keys))
+	}
+}
+
+func TestTrieClone(t *testing.T) {
+	trie := createTestTrie()
+	clone := trie.Clone()
+	if clone.Len() != trie.Len() {
+		t.Errorf("Wrong length %d of clone, expected %d", clone.Len(), trie.Len())
+	}
+	for _, k := range keys {
+		if v, ok := clone.GetString(k); !ok || v.(string) != k {
+			t.Errorf("Wrong value %v, %v for key %s in clone", v, ok, k)
+		}
+	}
+	// "abcdeX" splits the edge shared with the original.
+	clone.Add([]byte("abcdeX"), "abcdeX")
+	clone.Add([]byte(keys[0]), "changed")
+	clone.Delete([]byte(keys[1]))
+	if trie.ContainsString("abcdeX") {
+		t.Errorf("Key added to clone found in original")
+	}
+	for _, k := range keys {
+		if v, ok := trie.GetString(k); !ok || v.(string) != k {
+			t.Errorf("Wrong value %v, %v for key %s in original", v, ok, k)
+		}
+	}
+	if trie.Len() != len(keys) {
+		t.Errorf("Wrong length %d of original, expected %d", trie.Len(), len(keys))
+	}
+}
+
+func TestTrieMerge(t *testing.T) {
+	trie := NewTrie()
+	trie.Add([]byte("abc"), 1)
+	trie.Add([]byte("abd"), 2)
+	other := NewTrie()
+	other.Add([]byte("abd"), 20)
+	other.Add([]byte("ab"), 30)
+	other.Add([]byte("x"), 40)
+	conflicts := []string{}
+	trie.Merge(other, func(existing, incoming Value) Value {
+		conflicts = append(conflicts, fmt.Sprint(existing, incoming))
+		return existing.(int) + incoming.(int)
+	})
+	if len(conflicts) != 1 || conflicts[0] != "2 20" {
+		t.Errorf("Wrong conflicts %v", conflicts)
+	}
+	expected := map[string]int{"abc": 1, "abd": 22, "ab": 30, "x": 40}
+	for k, e := range expected {
+		if v, ok := trie.GetString(k); !ok || v.(int) != e {
+			t.Errorf("Wrong value %v, %v for key %s, expected %d", v, ok, k, e)
+		}
+	}
+	if trie.Len() != len(expected) {
+		t.Errorf("Wrong length %d, expected %d", trie.Len(), len(expected))
+	}
+	if other.Len() != 3 {
+		t.Errorf("Merge should not modify other")
+	}
+}
+
+func TestTrieMergeNilConflict(t *testing.T) {
+	trie := createTestTrie()
+	other := NewTrie()
+	other.Add([]byte(keys[0]), "incoming")
+	trie.Merge(other, nil)
+	if v, _ := trie.GetString(keys[0]); v.(string) != "incoming" {
+		t.Errorf("Incoming value should win, but %v", v)
+	}
+	if trie.Len() != len(keys) {
+		t.Errorf("Wrong length %d, expected %d", trie.Len(), len(keys))
+	}
+}
+
+func TestTrieGetOrAdd(t *testing.T) {
+	trie := createTestTrie()
+	v, loaded := trie.GetOrAdd([]byte(keys[0]), "new")
+	if !loaded || v.(string) != keys[0] {
+		t.Errorf("Wrong result %v, %v for existing key", v, loaded)
+	}
+	v, loaded = trie.GetOrAdd([]byte("abcde"), "new")
+	if loaded || v.(string) != "new" {
+		t.Errorf("Wrong result %v, %v for new key", v, loaded)
+	}
+	if v, ok := trie.GetString("abcde"); !ok || v.(string) != "new" {
+		t.Errorf("Wrong value %v, %v after GetOrAdd", v, ok)
+	}
+	if trie.Len() != len(keys)+1 {
+		t.Errorf("Wrong length %d, expected %d", trie.Len(), len(keys)+1)
+	}
+}
+
+func TestTrieAddUnique(t *testing.T) {
+	trie := createTestTrie()
+	if err := trie.AddUnique([]byte("abcde"), "first"); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	err := trie.AddUnique([]byte("abcde"), "second")
+	if err == nil || !strings.Contains(err.Error(), "abcde") {
+		t.Errorf("Expected error naming the key, but got %v", err)
+	}
+	if v, _ := trie.GetString("abcde"); v != "first" || trie.Len() != len(keys)+1 {
+		t.Errorf("Duplicate key changed the value to %v", v)
+	}
+	if err := trie.AddUnique([]byte(keys[0]), nil); err == nil {
+		t.Errorf("Expected error for existing key %s", keys[0])
+	}
+}
+
+func TestTrieGetOrCompute(t *testing.T) {
+	trie := createTestTrie()
+	calls := map[string]int{}
+	compute := func(key []byte) Value {
+		calls[string(key)]++
+		return "computed " + string(key)
+	}
+	for i := 0; i < 2; i++ {
+		for _, k := range append([]string{"abcde", "x"}, keys...) {
+			expected := k
+			if k == "abcde" || k == "x" {
+				expected = "computed " + k
+			}
+			if v := trie.GetOrCompute([]byte(k), compute); v != expected {
+				t.Errorf("Wrong value %v for key %s, expected %s", v, k, expected)
+			}
+		}
+	}
+	if !reflect.DeepEqual(calls, map[string]int{"abcde": 1, "x": 1}) {
+		t.Errorf("Wrong calls of compute %v", calls)
+	}
+	if trie.Len() != len(keys)+2 {
+		t.Errorf("Wrong length %d, expected %d", trie.Len(), len(keys)+2)
+	}
+}
+
+func TestTrieCaseInsensitive(t *testing.T) {
+	trie := NewTrieCaseInsensitive()
+	trie.Add([]byte("Hello"), 1)
+	trie.Add([]byte("HELLO world"), 2)
+	trie.Add([]byte("hello-\xC3\x89"), 3)
+	for _, k := range []string{"hello", "HELLO", "hElLo"} {
+		if v, ok := trie.GetString(k); !ok || v.(int) != 1 {
+			t.Errorf("Wrong value %v, %v for key %s", v, ok, k)
+		}
+		if v, ok := trie.GetBytes([]byte(k)); !ok || v.(int) != 1 {
+			t.Errorf("Wrong value %v, %v for key %s", v, ok, k)
+		}
+	}
+	if trie.Len() != 3 {
+		t.Errorf("Wrong length %d, expected 3", trie.Len())
+	}
+	// Only ASCII letters are folded.
+	if v, ok := trie.GetString("HELLO-\xC3\xA9"); ok {
+		t.Errorf("Unexpected value %v for non-ASCII key", v)
+	}
+	m, ok := trie.MatchLongestPrefixString("Hello WORLD!")
+	if !ok || m.PrefixLength != 11 || m.Value.(int) != 2 {
+		t.Errorf("Wrong longest prefix %v, %v", m, ok)
+	}
+	r := trie.KeysWithPrefixString("HEL")
+	if len(r) != 3 || string(r[1]) != "hello world" {
+		t.Errorf("Wrong keys with prefix %q", r)
+	}
+	if v, ok := trie.DeleteString("HeLLo"); !ok || v.(int) != 1 {
+		t.Errorf("Wrong deleted value %v, %v", v, ok)
+	}
+	if trie.ContainsString("hello") {
+		t.Errorf("Deleted key still found")
+	}
+	plain := NewTrie()
+	plain.Add([]byte("Hello"), 1)
+	if plain.ContainsString("hello") {
+		t.Errorf("Default trie should be case sensitive")
+	}
+}
+
+func TestTrieReplace(t *testing.T) {
+	trie := createTestTrie()
+	old, existed := trie.Replace([]byte("abcde"), 1)
+	if existed || old != nil {
+		t.Errorf("Wrong result %v, %v for new key", old, existed)
+	}
+	old, existed = trie.Replace([]byte("abcde"), 2)
+	if !existed || old.(int) != 1 {
+		t.Errorf("Wrong result %v, %v for replaced key", old, existed)
+	}
+	if v, _ := trie.GetString("abcde"); v.(int) != 2 {
+		t.Errorf("Wrong value %v after replace", v)
+	}
+	trie.Add([]byte("nil"), nil)
+	old, existed = trie.Replace([]byte("nil"), 3)
+	if !existed || old != nil {
+		t.Errorf("Wrong result %v, %v for replaced nil value", old, existed)
+	}
+	if trie.Len() != len(keys)+2 {
+		t.Errorf("Wrong length %d, expected %d", trie.Len(), len(keys)+2)
+	}
+}
+
+func TestTrieValues(t *testing.T) {
+	trie := createTestTrie()
+	sorted := append([]string{}, keys...)
+	sort.Strings(sorted)
+	r := trie.Values()
+	if len(r) != len(sorted) {
+		t.Fatalf("Wrong values %v vs. %v", r, sorted)
+	}
+	for i, k := range sorted {
+		if r[i].(string) != k {
+			t.Errorf("Wrong value[%d] %v vs. %s", i, r[i], k)
+		}
+	}
+	if r := NewTrie().Values(); len(r) != 0 {
+		t.Errorf("Unexpected values %v of empty trie", r)
+	}
+}
+
+func TestTrieMemoryBytes(t *testing.T) {
+	trie := createTestTrie()
+	small := trie.MemoryBytes()
+	if empty := NewTrie().MemoryBytes(); empty <= 0 || empty >= small {
+		t.Errorf("Wrong footprint %d of empty trie, %d of test trie", empty, small)
+	}
+	trie.AddString("abcdefghijklmnopqrstuvwxyz", nil)
+	larger := trie.MemoryBytes()
+	if larger <= small {
+		t.Errorf("Footprint %d should grow with a new key from %d", larger, small)
+	}
+	trie.AddString("abcdefghijklmnopqrstuvwxyz0123456789", nil)
+	if longer := trie.MemoryBytes(); longer <= larger {
+		t.Errorf("Footprint %d should grow with a longer key from %d", longer, larger)
+	}
+}
+
+func TestTrieByteHistogram(t *testing.T) {
+	trie := createTestTrie()
+	h := trie.ByteHistogram()
+	// Every key starts with "ab", only abXdxyz lacks 'c' and abcdefgXXX has three 'X'.
+	for b, expected := range map[byte]int{'a': 8, 'b': 8, 'c': 7, 'X': 4, 'g': 5, 'k': 2, 'y': 2, 'q': 0} {
+		if h[b] != expected {
+			t.Errorf("Wrong count %d of %c, expected %d", h[b], b, expected)
+		}
+	}
+	total := 0
+	for _, n := range h {
+		total += n
+	}
+	if total != trie.Stats().TotalKeyBytes {
+		t.Errorf("Wrong total %d, expected %d", total, trie.Stats().TotalKeyBytes)
+	}
+	if h := NewTrie().ByteHistogram(); h != [256]int{} {
+		t.Errorf("Unexpected counts of empty trie")
+	}
+}
+
+func TestTrieLongestKey(t *testing.T) {
+	trie := createTestTrie()
+	longest := ""
+	for _, k := range keys {
+		if len(k) > len(longest) {
+			longest = k
+		}
+	}
+	if n := trie.LongestKeyLen(); n != len(longest) {
+		t.Errorf("Wrong length %d, expected %d", n, len(longest))
+	}
+	if k := trie.LongestKey(); string(k) != longest {
+		t.Errorf("Wrong key %s, expected %s", k, longest)
+	}
+	trie.AddString("zzzzzzzzzzz", nil)
+	trie.AddString("abcdefghijj", nil)
+	if k := trie.LongestKey(); string(k) != "abcdefghijj" {
+		t.Errorf("Wrong key %s, expected the first of the longest keys", k)
+	}
+	empty := NewTrie()
+	if k := empty.LongestKey(); k != nil || empty.LongestKeyLen() != 0 {
+		t.Errorf("Unexpected key %q of empty trie", k)
+	}
+	empty.AddString("", nil)
+	if k := empty.LongestKey(); k == nil || len(k) != 0 {
+		t.Errorf("Wrong key %q, expected the empty key", k)
+	}
+}
+
+func TestTrieKeys(t *testing.T) {
+	trie := createTestTrie()
+	sorted := append([]string{}, keys...)
+	sort.Strings(sorted)
+	r := trie.Keys()
+	if len(r) != len(sorted) {
+		t.Fatalf("Wrong keys %q vs. %q", r, sorted)
+	}
+	for i, k := range sorted {
+		if string(r[i]) != k {
+			t.Errorf("Wrong key[%d] %s vs. %s", i, r[i], k)
+		}
+	}
+	// Modifying a returned key must not affect the trie.
+	for _, k := range r {
+		for i := range k {
+			k[i] = 'X'
+		}
+	}
+	if !trie.Equal(createTestTrie()) {
+		t.Errorf("Keys returned memory of the trie")
+	}
+	if r := trie.KeysString(); !reflect.DeepEqual(r, sorted) {
+		t.Errorf("Wrong keys %v vs. %v", r, sorted)
+	}
+	if r := NewTrie().KeysString(); len(r) != 0 {
+		t.Errorf("Unexpected keys %v of empty trie", r)
+	}
+}
+
+func TestTriePartition(t *testing.T) {
+	trie := NewTrie()
+	words := []string{"", "apple", "apricot", "banana", "blueberry", "cherry"}
+	for _, w := range words {
+		trie.AddString(w, w)
+	}
+	r := trie.Partition()
+	expected := map[byte][]string{
+		'a': {"apple", "apricot"},
+		'b': {"banana", "blueberry"},
+		'c': {"cherry"},
+	}
+	if len(r) != len(expected) {
+		t.Fatalf("Wrong partition %q, expected %q", r, expected)
+	}
+	for b, e := range expected {
+		if fmt.Sprintf("%s", r[b]) != fmt.Sprint(e) {
+			t.Errorf("Wrong keys %q for %c, expected %q", r[b], b, e)
+		}
+	}
+	if r := createTestTrie().Partition(); len(r) != 1 || len(r['a']) != len(keys) {
+		t.Errorf("All test keys should be in one partition, but got %q", r)
+	}
+}
+
+func TestTrieStats(t *testing.T) {
+	// root -> ab -> cd -> efg -> hi -> jk
+	//                       \-> k
+	//                       \-> XXX
+	//                 \-> f
+	//                 \-> xyz
+	//           \-> Xdxyz
+	expected := TrieStats{NodeCount: 11, LeafCount: 6, MaxDepth: 5, TotalKeyBytes: 64}
+	if stats := createTestTrie().Stats(); stats != expected {
+		t.Errorf("Wrong stats %+v, expected %+v", stats, expected)
+	}
+	expected = TrieStats{NodeCount: 1, LeafCount: 1}
+	if stats := NewTrie().Stats(); stats != expected {
+		t.Errorf("Wrong stats %+v of empty trie, expected %+v", stats, expected)
+	}
+}
+
+func TestTrieFindAllOccurrences(t *testing.T) {
+	trie := NewTrie()
+	for _, k := range []string{"he", "she", "his", "hers"} {
+		trie.Add([]byte(k), k)
+	}
+	text := "ushers and his"
+	expected := []Occurrence{{1, 3, "she"}, {2, 2, "he"}, {2, 4, "hers"}, {11, 3, "his"}}
+	for _, r := range [][]Occurrence{trie.FindAllOccurrencesBytes([]byte(text)), trie.FindAllOccurrencesString(text)} {
+		if len(r) != len(expected) {
+			t.Fatalf("Wrong occurrences %v vs. %v", r, expected)
+		}
+		for i, o := range expected {
+			if r[i] != o {
+				t.Errorf("Wrong occurrence[%d] %v vs. %v", i, r[i], o)
+			}
+			if text[r[i].Start:r[i].Start+r[i].Length] != r[i].Value.(string) {
+				t.Errorf("Occurrence[%d] %v doesn't match the text", i, r[i])
+			}
+		}
+	}
+	if r := trie.FindAllOccurrencesString("xyz"); len(r) != 0 {
+		t.Errorf("Unexpected occurrences %v", r)
+	}
+}
+
+func TestTrieString(t *testing.T) {
+	trie := createTestTrie()
+	s := trie.String()
+	lines := strings.Split(s, "\n")
+	for i, expected := range []string{
+		`""`,
+		`  "ab"`,
+		`    "Xdxyz": abXdxyz`,
+		`    "cd"`,
+		`      "efg": abcdefg`,
+		`        "XXX": abcdefgXXX`,
+		`        "hi": abcdefghi`,
+		`          "jk": abcdefghijk`,
+	} {
+		if lines[i] != expected {
+			t.Errorf("Wrong line[%d] %s vs. %s", i, lines[i], expected)
+		}
+	}
+	if !strings.Contains(s, "\n      \"xyz\": abcdxyz\n") {
+		t.Errorf("Missing node xyz in %s", s)
+	}
+	if s != trie.Clone().String() {
+		t.Errorf("String should be stable")
+	}
+}
+
+func TestTrieDeletePrefixBytes(t *testing.T) {
+	trie := createTestTrie()
+	if n := trie.DeletePrefixBytes([]byte("abcdefg")); n != len(completions) {
+		t.Errorf("Wrong number of deleted keys %d, expected %d", n, len(completions))
+	}
+	for _, k := range completions {
+		if trie.ContainsString(k) {
+			t.Errorf("Deleted key %s still found", k)
+		}
+	}
+	for _, k := range []string{"abcdf", "abcdxyz", "abXdxyz"} {
+		if v, ok := trie.GetString(k); !ok || v.(string) != k {
+			t.Errorf("Wrong value %v, %v for key %s", v, ok, k)
+		}
+	}
+	if trie.Len() != len(keys)-len(completions) {
+		t.Errorf("Wrong length %d, expected %d", trie.Len(), len(keys)-len(completions))
+	}
+	if n := trie.DeletePrefixBytes([]byte("abcdefg")); n != 0 {
+		t.Errorf("Wrong number of deleted keys %d, expected 0", n)
+	}
+	if n := trie.DeletePrefixBytes([]byte("abX")); n != 1 {
+		t.Errorf("Wrong number of deleted keys %d, expected 1", n)
+	}
+	// "ab" and "cd" are merged after "abXdxyz" is gone.
+	if child := trie.children['a']; string(child.prefix) != "abcd" {
+		t.Errorf("Trie not compacted, prefix %s", child.prefix)
+	}
+}
+
+func TestTrieDeletePrefixString(t *testing.T) {
+	trie := createTestTrie()
+	// "abcde" ends in the middle of an edge.
+	if n := trie.DeletePrefixString("abcde"); n != len(completions) {
+		t.Errorf("Wrong number of deleted keys %d, expected %d", n, len(completions))
+	}
+	if !trie.ContainsString("abcdf") {
+		t.Errorf("Unable to find key abcdf")
+	}
+	if n := trie.DeletePrefixString("abcdefX"); n != 0 {
+		t.Errorf("Wrong number of deleted keys %d, expected 0", n)
+	}
+	if n := trie.DeletePrefixString(""); n != 3 {
+		t.Errorf("Wrong number of deleted keys %d, expected 3", n)
+	}
+	if trie.Len() != 0 || len(trie.children) != 0 {
+		t.Errorf("Trie should be empty, but %s", trie)
+	}
+}
+
+func TestTrieCountPrefixBytes(t *testing.T) {
+	trie := createTestTrie()
+	if n := trie.CountPrefixBytes([]byte("abcdefg")); n != len(completions) {
+		t.Errorf("Wrong count %d, expected %d", n, len(completions))
+	}
+	if n := trie.CountPrefixBytes(nil); n != len(keys) {
+		t.Errorf("Wrong count %d, expected %d", n, len(keys))
+	}
+	if n := trie.CountPrefixBytes([]byte("abcdefX")); n != 0 {
+		t.Errorf("Wrong count %d, expected 0", n)
+	}
+}
+
+func TestTrieCountPrefixString(t *testing.T) {
+	trie := createTestTrie()
+	for prefix, expected := range map[string]int{"abcde": 5, "abc": 7, "abcdefghij": 1, "abcdefghijkl": 0, "b": 0} {
+		if n := trie.CountPrefixString(prefix); n != expected {
+			t.Errorf("Wrong count %d for prefix %s, expected %d", n, prefix, expected)
+		}
+	}
+}
+
+func TestTrieMatchLongestPrefixRunes(t *testing.T) {
+	trie := NewTrie()
+	trie.Add([]byte("ca"), "ca")
+	// The first byte of "é", which is "\xc3\xa9" in UTF-8.
+	trie.Add([]byte("caf\xc3"), "split")
+	input := "café au lait"
+	if m, _ := trie.MatchLongestPrefixString(input); m.PrefixLength != 4 {
+		t.Errorf("Byte level match should split the rune, but %v", m)
+	}
+	m, ok := trie.MatchLongestPrefixRunesString(input)
+	if !ok || m.PrefixLength != 2 || m.Value.(string) != "ca" {
+		t.Errorf("Wrong rune level match %v, %v", m, ok)
+	}
+	trie.Add([]byte("café"), "café")
+	m, ok = trie.MatchLongestPrefixRunesBytes([]byte(input))
+	if !ok || m.PrefixLength != 5 || m.Value.(string) != "café" {
+		t.Errorf("Wrong rune level match %v, %v", m, ok)
+	}
+	m, ok = trie.MatchLongestPrefixRunesBytes([]byte("café"))
+	if !ok || m.PrefixLength != 5 {
+		t.Errorf("Wrong rune level match %v, %v at end of input", m, ok)
+	}
+	if m, ok := trie.MatchLongestPrefixRunesString("c"); ok {
+		t.Errorf("Unexpected match %v", m)
+	}
+}
+
+func TestTrieAddAll(t *testing.T) {
+	trie := NewTrie()
+	k := [][]byte{}
+	v := []Value{}
+	for _, key := range keys {
+		k = append(k, []byte(key))
+		v = append(v, key)
+	}
+	k = append(k, []byte(keys[0]))
+	v = append(v, "last")
+	if err := trie.AddAll(k, v); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	for _, key := range keys[1:] {
+		if v, ok := trie.GetString(key); !ok || v.(string) != key {
+			t.Errorf("Wrong value %v, %v for key %s", v, ok, key)
+		}
+	}
+	if v, _ := trie.GetString(keys[0]); v.(string) != "last" {
+		t.Errorf("Later value of duplicated key should win, but %v", v)
+	}
+	if trie.Len() != len(keys) {
+		t.Errorf("Wrong length %d, expected %d", trie.Len(), len(keys))
+	}
+	if err := trie.AddAll(k, v[1:]); err == nil {
+		t.Errorf("Expected error for different lengths")
+	}
+}
+
+func benchmarkKeys(n int) ([][]byte, []Value) {
+	keys := make([][]byte, n)
+	values := make([]Value, n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("%x", uint32(i)*2654435761))
+		values[i] = i
+	}
+	return keys, values
+}
+
+func BenchmarkTrieAdd(b *testing.B) {
+	keys, values := benchmarkKeys(10000)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		trie := NewTrie()
+		for i, k := range keys {
+			trie.Add(k, values[i])
+		}
+	}
+}
+
+func BenchmarkTrieAddAll(b *testing.B) {
+	keys, values := benchmarkKeys(10000)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		NewTrie().AddAll(keys, values)
+	}
+}
+
+func TestTrieCountApprox(t *testing.T) {
+	trie := createTestTrie()
+	if count, exact := trie.CountApprox(100); !exact || count != len(keys) {
+		t.Errorf("Wrong count %d, %v, expected exactly %d", count, exact, len(keys))
+	}
+	if count, exact := trie.CountApprox(trie.Stats().NodeCount); !exact || count != len(keys) {
+		t.Errorf("Wrong count %d, %v with a budget of all nodes", count, exact)
+	}
+	if count, exact := trie.CountApprox(3); exact || count < 0 {
+		t.Errorf("Wrong count %d, %v with a small budget", count, exact)
+	}
+	if count, exact := (*Trie)(nil).CountApprox(0); !exact || count != 0 {
+		t.Errorf("Wrong count %d, %v of nil trie", count, exact)
+	}
+	large := NewTrie()
+	for i, k := range randomKeys(20000) {
+		large.Add(k, i)
+	}
+	count, exact := large.CountApprox(2000)
+	if exact || count < large.Len()/2 || count > large.Len()*2 {
+		t.Errorf("Implausible count %d, %v, expected about %d", count, exact, large.Len())
+	}
+}
+
+func TestTrieReserve(t *testing.T) {
+	trie := createTestTrie()
+	trie.Reserve(1000)
+	trie.AddString("xyz", "xyz")
+	if !trie.ContainsString("xyz") || trie.Len() != len(keys)+1 {
+		t.Errorf("Reserve should keep the keys")
+	}
+	for _, k := range keys {
+		if v, ok := trie.GetString(k); !ok || v != k {
+			t.Errorf("Wrong value %v, %v for key %s", v, ok, k)
+		}
+	}
+	var empty Trie
+	empty.Reserve(0)
+	empty.Reserve(-1)
+	if empty.Len() != 0 {
+		t.Errorf("Unexpected length %d", empty.Len())
+	}
+}
+
+func randomKeys(n int) [][]byte {
+	rnd := rand.New(rand.NewSource(1))
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = make([]byte, 8)
+		rnd.Read(keys[i])
+	}
+	return keys
+}
+
+func benchmarkTrieLoad(b *testing.B, reserve bool) {
+	keys := randomKeys(1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		trie := NewTrie()
+		if reserve {
+			trie.Reserve(len(keys))
+		}
+		for i, k := range keys {
+			trie.Add(k, i)
+		}
+	}
+}
+
+func BenchmarkTrieLoad(b *testing.B) {
+	benchmarkTrieLoad(b, false)
+}
+
+func BenchmarkTrieLoadReserved(b *testing.B) {
+	benchmarkTrieLoad(b, true)
+}
+
+func TestBuildSorted(t *testing.T) {
+	sorted := append([]string{"", "abcdefg"}, keys...)
+	sort.Strings(sorted)
+	k := make([][]byte, len(sorted))
+	v := make([]Value, len(sorted))
+	for i, s := range sorted {
+		k[i] = []byte(s)
+		v[i] = s
+	}
+	trie, err := BuildSorted(k, v)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expected := createTestTrie()
+	expected.AddString("", "")
+	if !trie.Equal(expected) || trie.Len() != len(keys)+1 {
+		t.Errorf("Wrong trie %v, expected %v", trie.ToMap(), expected.ToMap())
+	}
+	if s, e := trie.Stats(), expected.Stats(); s != e {
+		t.Errorf("Wrong structure %+v, expected %+v", s, e)
+	}
+	random := randomKeys(1000)
+	sort.Slice(random, func(i, j int) bool {
+		return string(random[i]) < string(random[j])
+	})
+	built, err := BuildSorted(random, make([]Value, len(random)))
+	added := NewTrie()
+	added.AddAll(random, make([]Value, len(random)))
+	if err != nil || !built.Equal(added) || built.Stats() != added.Stats() {
+		t.Errorf("Built trie differs from the added one: %v", err)
+	}
+	if _, err := BuildSorted([][]byte{[]byte("b"), []byte("a")}, []Value{1, 2}); err == nil {
+		t.Errorf("Expected error for unsorted keys")
+	}
+	if _, err := BuildSorted(k, v[1:]); err == nil {
+		t.Errorf("Expected error for different lengths")
+	}
+}
+
+func BenchmarkBuildSorted(b *testing.B) {
+	keys, values := benchmarkKeys(10000)
+	sort.Slice(keys, func(i, j int) bool {
+		return string(keys[i]) < string(keys[j])
+	})
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		BuildSorted(keys, values)
+	}
+}
+
+func BenchmarkTrieAddSorted(b *testing.B) {
+	keys, values := benchmarkKeys(10000)
+	sort.Slice(keys, func(i, j int) bool {
+		return string(keys[i]) < string(keys[j])
+	})
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		trie := NewTrie()
+		for i, k := range keys {
+			trie.Add(k, values[i])
+		}
+	}
+}
+
+func TestTrieEqual(t *testing.T) {
+	trie := createTestTrie()
+	reversed := NewTrie()
+	for i := len(keys) - 1; i >= 0; i-- {
+		reversed.Add([]byte(keys[i]), keys[i])
+	}
+	if !trie.Equal(reversed) || !reversed.Equal(trie) {
+		t.Errorf("Tries with the same keys should be equal")
+	}
+	if !NewTrie().Equal(NewTrie()) {
+		t.Errorf("Empty tries should be equal")
+	}
+	other := createTestTrie()
+	other.Add([]byte(keys[0]), "different")
+	if trie.Equal(other) {
+		t.Errorf("Tries with different values should not be equal")
+	}
+	other = createTestTrie()
+	other.Delete([]byte(keys[0]))
+	other.Add([]byte("abcde"), keys[0])
+	if trie.Equal(other) || other.Equal(trie) {
+		t.Errorf("Tries with different keys should not be equal")
+	}
+	slices := NewTrie()
+	slices.Add([]byte("a"), []int{1, 2})
+	other = NewTrie()
+	other.Add([]byte("a"), []int{1, 2})
+	if !slices.Equal(other) {
+		t.Errorf("Values should be compared deeply")
+	}
+}
+
+func TestTrieEqualFunc(t *testing.T) {
+	type point struct{ x, y int }
+	a, b := NewTrie(), NewTrie()
+	a.AddString("p", &point{1, 2})
+	b.AddString("p", &point{1, 2})
+	samePoint := func(x, y Value) bool {
+		return *x.(*point) == *y.(*point)
+	}
+	if !a.EqualFunc(b, samePoint) {
+		t.Errorf("Tries with equal pointees should be equal")
+	}
+	samePointer := func(x, y Value) bool {
+		return x == y
+	}
+	if a.EqualFunc(b, samePointer) {
+		t.Errorf("Tries with different pointers should not be equal")
+	}
+	b.AddString("p", &point{2, 1})
+	if a.EqualFunc(b, samePoint) {
+		t.Errorf("Tries with different pointees should not be equal")
+	}
+}
+
+func TestTrieLongestCommonKeyPrefix(t *testing.T) {
+	trie := NewTrie()
+	if p := trie.LongestCommonKeyPrefix(); p == nil || len(p) != 0 {
+		t.Errorf("Wrong common prefix %q of empty trie", p)
+	}
+	for _, k := range []string{"abcdefg", "abcdxyz", "abcdf"} {
+		trie.Add([]byte(k), k)
+	}
+	if p := trie.LongestCommonKeyPrefix(); string(p) != "abcd" {
+		t.Errorf("Wrong common prefix %q, expected abcd", p)
+	}
+	trie.Add([]byte("abc"), "abc")
+	if p := trie.LongestCommonKeyPrefix(); string(p) != "abc" {
+		t.Errorf("Wrong common prefix %q, expected abc", p)
+	}
+	trie.Add([]byte("xyz"), "xyz")
+	if p := trie.LongestCommonKeyPrefix(); len(p) != 0 {
+		t.Errorf("Wrong common prefix %q, expected empty", p)
+	}
+	if p := createTestTrie().LongestCommonKeyPrefix(); string(p) != "ab" {
+		t.Errorf("Wrong common prefix %q, expected ab", p)
+	}
+}
+
+func TestTrieMatchWildcardBytes(t *testing.T) {
+	trie := createTestTrie()
+	r := trie.MatchWildcardBytes([]byte("ab?dxyz"), '?')
+	if len(r) != 2 || string(r[0]) != "abXdxyz" || string(r[1]) != "abcdxyz" {
+		t.Errorf("Wrong matches %q", r)
+	}
+	r = trie.MatchWildcardBytes([]byte("abcdefg??"), '?')
+	if len(r) != 1 || string(r[0]) != "abcdefghi" {
+		t.Errorf("Wrong matches %q", r)
+	}
+	if r := trie.MatchWildcardBytes([]byte("ab?d"), '?'); len(r) != 0 {
+		t.Errorf("Unexpected matches %q", r)
+	}
+}
+
+func TestTrieMatchWildcardString(t *testing.T) {
+	trie := createTestTrie()
+	r := trie.MatchWildcardString("abcd***", '*')
+	if len(r) != 2 || string(r[0]) != "abcdefg" || string(r[1]) != "abcdxyz" {
+		t.Errorf("Wrong matches %q", r)
+	}
+	r = trie.MatchWildcardString("???????", '?')
+	if len(r) != 3 || string(r[0]) != "abXdxyz" || string(r[1]) != "abcdefg" || string(r[2]) != "abcdxyz" {
+		t.Errorf("Wrong matches %q", r)
+	}
+	if r := trie.MatchWildcardString("", '?'); len(r) != 0 {
+		t.Errorf("Unexpected matches %q", r)
+	}
+}
+
+func TestTrieMatchWildcardCaseInsensitive(t *testing.T) {
+	trie := NewTrieCaseInsensitive()
+	for _, key := range []string{"xa", "xb", "ya"} {
+		trie.AddString(key, key)
+	}
+	if r := trie.MatchWildcardString("xa", 'A'); len(r) != 1 || string(r[0]) != "xa" {
+		t.Errorf("Wrong matches %q", r)
+	}
+	if r := trie.MatchWildcardString("XA", 'A'); len(r) != 2 || string(r[0]) != "xa" || string(r[1]) != "xb" {
+		t.Errorf("Wrong matches %q", r)
+	}
+	if r := trie.MatchWildcardString("?A", '?'); len(r) != 2 || string(r[0]) != "xa" || string(r[1]) != "ya" {
+		t.Errorf("Wrong matches %q", r)
+	}
+}
+
+func TestTrieGlobMatchBytes(t *testing.T) {
+	trie := createTestTrie()
+	sorted := append([]string{}, keys...)
+	sort.Strings(sorted)
+	cases := map[string][]string{
+		"ab*xyz":  {"abXdxyz", "abcdxyz"},
+		"ab?dxyz": {"abXdxyz", "abcdxyz"},
+		"ab*":     sorted,
+		"*":       sorted,
+		"***":     sorted,
+		"*k":      {"abcdefghijk", "abcdefgk"},
+		"*g*i*":   {"abcdefghi", "abcdefghijk"},
+		"abcd?":   {"abcdf"},
+		"abc?":    {},
+		"abcdf*":  {"abcdf"},
+		"?*?X*":   {"abXdxyz", "abcdefgXXX"},
+		"":        {},
+	}
+	for pattern, expected := range cases {
+		r := trie.GlobMatchBytes([]byte(pattern))
+		if fmt.Sprintf("%s", r) != fmt.Sprint(expected) {
+			t.Errorf("Wrong matches %q for %s, expected %q", r, pattern, expected)
+		}
+	}
+}
+
+func TestTrieGlobMatchString(t *testing.T) {
+	trie := NewTrieCaseInsensitive()
+	trie.AddString("Hello", 1)
+	trie.AddString("", 2)
+	if r := trie.GlobMatchString("H*O"); len(r) != 1 || string(r[0]) != "hello" {
+		t.Errorf("Wrong matches %q", r)
+	}
+	if r := trie.GlobMatchString("*"); len(r) != 2 || string(r[0]) != "" {
+		t.Errorf("Wrong matches %q", r)
+	}
+}
+
+func TestTrieFuzzyMatchBytes(t *testing.T) {
+	trie := createTestTrie()
+	r := trie.FuzzyMatchBytes([]byte("abcdefgh"), 1)
+	if len(r) != 3 {
+		t.Fatalf("Wrong matches %v", r)
+	}
+	for i, k := range []string{"abcdefg", "abcdefghi", "abcdefgk"} {
+		if string(r[i].Key) != k || r[i].Value.(string) != k || r[i].Distance != 1 {
+			t.Errorf("Wrong match[%d] %v, expected %s", i, r[i], k)
+		}
+	}
+	r = trie.FuzzyMatchBytes([]byte("abcdxyz"), 0)
+	if len(r) != 1 || string(r[0].Key) != "abcdxyz" || r[0].Distance != 0 {
+		t.Errorf("Zero distance should be exact match, but %v", r)
+	}
+	if r := trie.FuzzyMatchBytes([]byte("abcdefgh"), 0); len(r) != 0 {
+		t.Errorf("Unexpected matches %v", r)
+	}
+}
+
+func TestTrieFuzzyMatchString(t *testing.T) {
+	trie := createTestTrie()
+	r := trie.FuzzyMatchString("abcxyz", 2)
+	if len(r) != 2 {
+		t.Fatalf("Wrong matches %v", r)
+	}
+	for i, expected := range []FuzzyResult{{[]byte("abXdxyz"), "abXdxyz", 2}, {[]byte("abcdxyz"), "abcdxyz", 1}} {
+		if string(r[i].Key) != string(expected.Key) || r[i].Distance != expected.Distance {
+			t.Errorf("Wrong match[%d] %v vs. %v", i, r[i], expected)
+		}
+	}
+	short := NewTrie()
+	for _, k := range []string{"", "a", "ab", "abc"} {
+		short.Add([]byte(k), k)
+	}
+	if r := short.FuzzyMatchString("", 2); len(r) != 3 || r[2].Distance != 2 {
+		t.Errorf("Empty query should match keys up to length 2, but %v", r)
+	}
+}
+
+func TestTrieSubTrieBytes(t *testing.T) {
+	trie := createTestTrie()
+	sub, ok := trie.SubTrieBytes([]byte("abcdefg"))
+	if !ok {
+		t.Fatalf("Unable to find sub trie")
+	}
+	if sub.Len() != len(completions) {
+		t.Errorf("Wrong length %d, expected %d", sub.Len(), len(completions))
+	}
+	for _, k := range completions {
+		if v, ok := sub.GetString(k[len("abcdefg"):]); !ok || v.(string) != k {
+			t.Errorf("Wrong value %v, %v for key %s", v, ok, k)
+		}
+	}
+	r := sub.MatchAllPrefixesString("hijklm")
+	if len(r) != 3 || r[0].PrefixLength != 0 || r[1].PrefixLength != 2 || r[2].PrefixLength != 4 {
+		t.Errorf("Wrong prefixes %v", r)
+	}
+	if _, ok := trie.SubTrieBytes([]byte("abcdefX")); ok {
+		t.Errorf("Unexpected sub trie")
+	}
+}
+
+func TestTrieSubTrieString(t *testing.T) {
+	trie := createTestTrie()
+	// "abcde" ends in the middle of an edge.
+	sub, ok := trie.SubTrieString("abcde")
+	if !ok {
+		t.Fatalf("Unable to find sub trie")
+	}
+	if v, ok := sub.GetString("fghi"); !ok || v.(string) != "abcdefghi" {
+		t.Errorf("Wrong value %v, %v", v, ok)
+	}
+	if m, ok := sub.MatchLongestPrefixString("fgXXXX"); !ok || m.PrefixLength != 5 {
+		t.Errorf("Wrong longest prefix %v, %v", m, ok)
+	}
+	if sub.ContainsString("") || sub.ContainsString("f") {
+		t.Errorf("Unexpected key in sub trie")
+	}
+	if sub.Len() != len(completions) {
+		t.Errorf("Wrong length %d, expected %d", sub.Len(), len(completions))
+	}
+	if sub, ok := trie.SubTrieString(""); !ok || sub.Len() != len(keys) {
+		t.Errorf("Empty prefix should return the whole trie")
+	}
+}
+
+func TestTrieUpdate(t *testing.T) {
+	trie := createTestTrie()
+	increment := func(v Value, ok bool) Value {
+		if !ok {
+			return 1
+		}
+		return v.(int) + 1
+	}
+	for i := 0; i < 5; i++ {
+		trie.Update([]byte("counter"), increment)
+	}
+	if v, ok := trie.GetString("counter"); !ok || v.(int) != 5 {
+		t.Errorf("Wrong counter %v, %v", v, ok)
+	}
+	trie.Update([]byte(keys[0]), func(v Value, ok bool) Value {
+		if !ok {
+			t.Errorf("Key %s should exist", keys[0])
+		}
+		return v.(string) + "!"
+	})
+	if v, _ := trie.GetString(keys[0]); v.(string) != keys[0]+"!" {
+		t.Errorf("Wrong updated value %v", v)
+	}
+	if trie.Len() != len(keys)+1 {
+		t.Errorf("Wrong length %d, expected %d", trie.Len(), len(keys)+1)
+	}
+}
+
+func TestTrieMinMaxKey(t *testing.T) {
+	trie := createTestTrie()
+	sorted := append([]string{}, keys...)
+	sort.Strings(sorted)
+	key, value, ok := trie.MinKey()
+	if !ok || string(key) != sorted[0] || value.(string) != sorted[0] {
+		t.Errorf("Wrong min key %s, %v, %v", key, value, ok)
+	}
+	key, value, ok = trie.MaxKey()
+	if expected := sorted[len(sorted)-1]; !ok || string(key) != expected || value.(string) != expected {
+		t.Errorf("Wrong max key %s, %v, %v", key, value, ok)
+	}
+	trie = NewTrie()
+	if key, value, ok := trie.MinKey(); ok {
+		t.Errorf("Unexpected min key %s, %v of empty trie", key, value)
+	}
+	if key, value, ok := trie.MaxKey(); ok {
+		t.Errorf("Unexpected max key %s, %v of empty trie", key, value)
+	}
+	trie.Add(nil, "root")
+	trie.Add([]byte("a"), "a")
+	if key, _, _ := trie.MinKey(); len(key) != 0 {
+		t.Errorf("Wrong min key %s, expected empty key", key)
+	}
+	if key, _, _ := trie.MaxKey(); string(key) != "a" {
+		t.Errorf("Wrong max key %s, expected a", key)
+	}
+}
+
+func TestTrieWalkRange(t *testing.T) {
+	trie := createTestTrie()
+	sorted := append([]string{}, keys...)
+	sort.Strings(sorted)
+	for _, r := range [][2]string{
+		{sorted[2], sorted[5]},
+		{"abcdefg", "abcdefg"},
+		{"abcdefga", "abcdefgj"},
+		{"", "zzz"},
+		{"", ""},
+		{"b", "a"},
+	} {
+		expected := []string{}
+		for _, k := range sorted {
+			if r[0] <= k && k <= r[1] {
+				expected = append(expected, k)
+			}
+		}
+		walked := []string{}
+		trie.WalkRange([]byte(r[0]), []byte(r[1]), func(key []byte, value Value) bool {
+			walked = append(walked, string(key))
+			return true
+		})
+		if strings.Join(walked, ",") != strings.Join(expected, ",") {
+			t.Errorf("Wrong keys %v in range %q, expected %v", walked, r, expected)
+		}
+	}
+	count := 0
+	trie.WalkRange([]byte("a"), []byte("b"), func(key []byte, value Value) bool {
+		count++
+		return count < 2
+	})
+	if count != 2 {
+		t.Errorf("WalkRange should stop after 2 keys, but visited %d", count)
+	}
+	folded := NewTrieCaseInsensitive()
+	for _, k := range []string{"Hello", "help", "World"} {
+		folded.AddString(k, k)
+	}
+	walked := []string{}
+	folded.WalkRange([]byte("HELLO"), []byte("HELP"), func(key []byte, value Value) bool {
+		walked = append(walked, string(key))
+		return true
+	})
+	if strings.Join(walked, ",") != "hello,help" {
+		t.Errorf("Wrong keys %v in case-insensitive range", walked)
+	}
+}
+
+func TestTrieWithOrder(t *testing.T) {
+	greater := func(a, b byte) bool { return a > b }
+	trie := NewTrieWithOrder(greater)
+	for _, k := range keys {
+		trie.Add([]byte(k), k)
+	}
+	expected := append([]string{}, keys...)
+	sort.Slice(expected, func(i, j int) bool {
+		a, b := expected[i], expected[j]
+		for n := 0; n < len(a) && n < len(b); n++ {
+			if a[n] != b[n] {
+				return greater(a[n], b[n])
+			}
+		}
+		return len(a) < len(b)
+	})
+	walked := []string{}
+	trie.Walk(func(key []byte, value Value) bool {
+		walked = append(walked, string(key))
+		return true
+	})
+	if strings.Join(walked, ",") != strings.Join(expected, ",") {
+		t.Errorf("Wrong order %v, expected %v", walked, expected)
+	}
+	if walked[0] != "abcdxyz" || walked[len(walked)-1] != "abXdxyz" {
+		t.Errorf("Keys should come out in descending order of bytes, but %v", walked)
+	}
+	r := trie.KeysWithPrefixString("abcdefg")
+	if len(r) != 5 || string(r[1]) != "abcdefgk" || string(r[4]) != "abcdefgXXX" {
+		t.Errorf("Wrong order of keys with prefix %q", r)
+	}
+}
+
+func TestTrieHasPrefixBytes(t *testing.T) {
+	trie := createTestTrie()
+	for _, p := range []string{"", "a", "abcd", "abcde", "abXd", "abcdefghijk"} {
+		if !trie.HasPrefixBytes([]byte(p)) {
+			t.Errorf("Prefix %s should exist", p)
+		}
+	}
+	if trie.ContainsBytes([]byte("abcd")) {
+		t.Errorf("Prefix abcd should not be a key")
+	}
+	for _, p := range []string{"b", "abcdX", "abcdefghijkl"} {
+		if trie.HasPrefixBytes([]byte(p)) {
+			t.Errorf("Unexpected prefix %s", p)
+		}
+	}
+}
+
+func TestTrieHasPrefixString(t *testing.T) {
+	trie := createTestTrie()
+	if !trie.HasPrefixString("abcd") || trie.ContainsString("abcd") {
+		t.Errorf("abcd should be a prefix but not a key")
+	}
+	if trie.HasPrefixString("abcdefgXY") {
+		t.Errorf("Unexpected prefix abcdefgXY")
+	}
+	if NewTrie().HasPrefixString("") {
+		t.Errorf("Empty trie should have no prefix")
+	}
+}
+
+func TestTrieNextBytesBytes(t *testing.T) {
+	trie := createTestTrie()
+	for prefix, expected := range map[string]string{
+		"":            "a",
+		"a":           "b",
+		"ab":          "Xc",
+		"abcd":        "efx",
+		"abcde":       "f",
+		"abcdefg":     "Xhk",
+		"abcdefghijk": "",
+		"abcdX":       "",
+		"b":           "",
+	} {
+		if r := trie.NextBytesBytes([]byte(prefix)); string(r) != expected {
+			t.Errorf("Wrong next bytes %q for %s, expected %q", r, prefix, expected)
+		}
+	}
+}
+
+func TestTrieNextBytesString(t *testing.T) {
+	trie := NewTrieCaseInsensitive()
+	trie.AddString("Hello", 1)
+	trie.AddString("Help", 2)
+	if r := trie.NextBytesString("HEL"); string(r) != "lp" {
+		t.Errorf("Wrong next bytes %q, expected \"lp\"", r)
+	}
+	if r := NewTrie().NextBytesString(""); len(r) != 0 {
+		t.Errorf("Unexpected next bytes %q of empty trie", r)
+	}
+}
+
+func TestTrieCommonPrefixLenBytes(t *testing.T) {
+	trie := createTestTrie()
+	for input, expected := range map[string]int{
+		noPrefixContent: 6,
+		"":              0,
+		"b":             0,
+		"abcd":          4,
+		"abcdefghijklm": 11,
+		"abXdxQ":        5,
+	} {
+		if n := trie.CommonPrefixLenBytes([]byte(input)); n != expected {
+			t.Errorf("Wrong length %d for %s, expected %d", n, input, expected)
+		}
+	}
+	if n := NewTrieCaseInsensitive().CommonPrefixLenString("abc"); n != 0 {
+		t.Errorf("Wrong length %d for empty trie", n)
+	}
+}
+
+func TestTrieCommonPrefixLenString(t *testing.T) {
+	trie := NewTrieCaseInsensitive()
+	trie.AddString("Hello", 1)
+	if n := trie.CommonPrefixLenString("HELP"); n != 3 {
+		t.Errorf("Wrong length %d, expected 3", n)
+	}
+}
+
+func valuePtr(v Value) *Value {
+	return &v
+}
+
+func TestTrieCompact(t *testing.T) {
+	trie := createTestTrie()
+	trie.Compact()
+	if stats := trie.Stats(); stats.NodeCount != 11 {
+		t.Errorf("Compact should not change a compact trie, but %+v", stats)
+	}
+	// root -> "a" -> "b" -> "c": 1
+	//             \-> "x" -> "y" (no value, no children)
+	//      -> "d" -> "e": 2
+	trie = &Trie{children: map[byte]*Trie{
+		'a': {prefix: []byte("a"), children: map[byte]*Trie{
+			'b': {prefix: []byte("b"), children: map[byte]*Trie{
+				'c': {prefix: []byte("c"), value: valuePtr(1)},
+			}},
+			'x': {prefix: []byte("x"), children: map[byte]*Trie{
+				'y': {prefix: []byte("y")},
+			}},
+		}},
+		'd': {prefix: []byte("d"), children: map[byte]*Trie{
+			'e': {prefix: []byte("e"), value: valuePtr(2)},
+		}},
+	}, size: 2}
+	before := trie.Stats()
+	trie.Compact()
+	after := trie.Stats()
+	if before.NodeCount != 8 || after.NodeCount != 3 {
+		t.Errorf("Wrong node count %d before and %d after compaction", before.NodeCount, after.NodeCount)
+	}
+	if v, ok := trie.GetString("abc"); !ok || v.(int) != 1 {
+		t.Errorf("Wrong value %v, %v for key abc", v, ok)
+	}
+	if v, ok := trie.GetString("de"); !ok || v.(int) != 2 {
+		t.Errorf("Wrong value %v, %v for key de", v, ok)
+	}
+	if trie.HasPrefixString("ax") {
+		t.Errorf("Empty branch should be removed")
+	}
+}
+
+func TestTrieValidate(t *testing.T) {
+	trie := createTestTrie()
+	if err := trie.Validate(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	for _, k := range []string{"abcdefghi", "abcdf", "abcdefg"} {
+		trie.DeleteString(k)
+		if err := trie.Validate(); err != nil {
+			t.Errorf("Unexpected error %v after deleting %s", err, k)
+		}
+	}
+	if err := NewTrie().Validate(); err != nil {
+		t.Errorf("Unexpected error %v of empty trie", err)
+	}
+	corrupted := map[string]*Trie{
+		"root prefix": {prefix: []byte("a")},
+		"empty prefix": {children: map[byte]*Trie{
+			'a': {value: valuePtr(1)},
+		}, size: 1},
+		"wrong first byte": {children: map[byte]*Trie{
+			'a': {prefix: []byte("b"), value: valuePtr(1)},
+		}, size: 1},
+		"empty leaf": {children: map[byte]*Trie{
+			'a': {prefix: []byte("a")},
+		}},
+		"single child": {children: map[byte]*Trie{
+			'a': {prefix: []byte("a"), children: map[byte]*Trie{
+				'b': {prefix: []byte("b"), value: valuePtr(1)},
+			}},
+		}, size: 1},
+		"wrong size": {children: map[byte]*Trie{
+			'a': {prefix: []byte("a"), value: valuePtr(1)},
+		}, size: 2},
+	}
+	for name, trie := range corrupted {
+		if err := trie.Validate(); err == nil {
+			t.Errorf("Expected error for %s", name)
+		}
+	}
+}
+
+func TestTrieWalkPrefixesBytes(t *testing.T) {
+	trie := createTestTrie()
+	lengths := []int{}
+	trie.WalkPrefixesBytes([]byte(content), func(m PrefixMatch) bool {
+		if m.Value.(string) != content[:m.PrefixLength] {
+			t.Errorf("Wrong value %v for prefix length %d", m.Value, m.PrefixLength)
+		}
+		lengths = append(lengths, m.PrefixLength)
+		return true
+	})
+	if fmt.Sprint(lengths) != "[7 9 11]" {
+		t.Errorf("Wrong prefix lengths %v", lengths)
+	}
+	trie.WalkPrefixesBytes([]byte(noPrefixContent), func(m PrefixMatch) bool {
+		t.Errorf("Unexpected prefix %v", m)
+		return true
+	})
+}
+
+func TestTrieWalkPrefixesString(t *testing.T) {
+	trie := createTestTrie()
+	lengths := []int{}
+	trie.WalkPrefixesString(content, func(m PrefixMatch) bool {
+		lengths = append(lengths, m.PrefixLength)
+		return len(lengths) < 2
+	})
+	if fmt.Sprint(lengths) != "[7 9]" {
+		t.Errorf("Wrong prefix lengths %v", lengths)
+	}
+}
+
+func createAbbreviationTrie() *Trie {
+	trie := NewTrie()
+	trie.Add([]byte("btw"), "by the way")
+	trie.Add([]byte("imo"), "in my opinion")
+	trie.Add([]byte("imho"), "in my humble opinion")
+	trie.Add([]byte("im"), "instant message")
+	trie.Add(nil, "never")
+	return trie
+}
+
+func TestTrieReplaceAllBytes(t *testing.T) {
+	trie := createAbbreviationTrie()
+	r := trie.ReplaceAllBytes([]byte("btw, imho imo im fine"), func(match []byte, value Value) []byte {
+		return []byte(value.(string))
+	})
+	expected := "by the way, in my humble opinion in my opinion instant message fine"
+	if string(r) != expected {
+		t.Errorf("Wrong replacement %q, expected %q", r, expected)
+	}
+	if r := trie.ReplaceAllBytes([]byte("nothing"), nil); string(r) != "nothing" {
+		t.Errorf("Wrong replacement %q", r)
+	}
+}
+
+func TestTrieReplaceAllString(t *testing.T) {
+	trie := createAbbreviationTrie()
+	r := trie.ReplaceAllString("imhoimbtw!", func(match string, value Value) string {
+		return "<" + strings.ToUpper(match) + ">"
+	})
+	if expected := "<IMHO><IM><BTW>!"; r != expected {
+		t.Errorf("Wrong replacement %q, expected %q", r, expected)
+	}
+}
+
+func TestTrieAppend(t *testing.T) {
+	trie := createTestTrie()
+	for i := 1; i <= 3; i++ {
+		trie.Append([]byte("multi"), i)
+	}
+	values, ok := trie.GetAllBytes([]byte("multi"))
+	if !ok || fmt.Sprint(values) != "[1 2 3]" {
+		t.Errorf("Wrong values %v, %v", values, ok)
+	}
+	trie.Append([]byte(keys[0]), "appended")
+	values, ok = trie.GetAllString(keys[0])
+	if !ok || len(values) != 2 || values[0].(string) != keys[0] || values[1].(string) != "appended" {
+		t.Errorf("Wrong values %v, %v", values, ok)
+	}
+	values, ok = trie.GetAllString(keys[1])
+	if !ok || len(values) != 1 || values[0].(string) != keys[1] {
+		t.Errorf("Wrong values %v, %v for key added by Add", values, ok)
+	}
+	if values, ok := trie.GetAllString("missing"); ok || values != nil {
+		t.Errorf("Unexpected values %v", values)
+	}
+	if trie.Len() != len(keys)+1 {
+		t.Errorf("Wrong length %d, expected %d", trie.Len(), len(keys)+1)
+	}
+}
+
+func TestTrieAppendClone(t *testing.T) {
+	trie := NewTrie()
+	trie.Append([]byte("a"), 1)
+	trie.Append([]byte("a"), 2)
+	clone := trie.Clone()
+	trie.Append([]byte("a"), 3)
+	clone.Append([]byte("a"), 4)
+	if values, _ := trie.GetAllString("a"); fmt.Sprint(values) != "[1 2 3]" {
+		t.Errorf("Wrong values %v", values)
+	}
+	if values, _ := clone.GetAllString("a"); fmt.Sprint(values) != "[1 2 4]" {
+		t.Errorf("Wrong values %v of clone", values)
+	}
+}
+
+func TestTrieTokenize(t *testing.T) {
+	trie := NewTrie()
+	for _, w := range []string{"for", "format", "if", " "} {
+		trie.AddString(w, w)
+	}
+	expected := []Token{
+		{0, 2, "if", true},
+		{2, 1, " ", true},
+		{3, 6, "format", true},
+		{9, 1, nil, false},
+		{10, 3, "for", true},
+		{13, 1, nil, false},
+	}
+	if r := trie.Tokenize([]byte("if format!forx")); !reflect.DeepEqual(r, expected) {
+		t.Errorf("Wrong tokens %v, expected %v", r, expected)
+	}
+	trie.AddString("", "empty")
+	if r := trie.TokenizeString("x"); !reflect.DeepEqual(r, []Token{{0, 1, nil, false}}) {
+		t.Errorf("The empty key should not match, but got %v", r)
+	}
+	if r := trie.TokenizeString(""); len(r) != 0 {
+		t.Errorf("Unexpected tokens %v of empty input", r)
+	}
+}
+
+func TestTrieAncestorValueBytes(t *testing.T) {
+	trie := createTestTrie()
+	for key, expected := range map[string]string{
+		"abcdefghijk": "abcdefghi",
+		"abcdefghi":   "abcdefg",
+		"abcdefghij":  "abcdefghi",
+		"abcdefgXXX":  "abcdefg",
+	} {
+		m, ok := trie.AncestorValueBytes([]byte(key))
+		if !ok || m.Value != expected || m.PrefixLength != len(expected) {
+			t.Errorf("Wrong ancestor %v, %v of %s, expected %s", m, ok, key, expected)
+		}
+	}
+	for _, key := range []string{"abcdefg", "abcdf", "abc", ""} {
+		if m, ok := trie.AncestorValueBytes([]byte(key)); ok {
+			t.Errorf("Unexpected ancestor %v of %s", m, key)
+		}
+	}
+	trie.AddString("", "root")
+	if m, ok := trie.AncestorValueString("abcdf"); !ok || m.Value != "root" || m.PrefixLength != 0 {
+		t.Errorf("Wrong ancestor %v, %v, expected the empty key", m, ok)
+	}
+}
+
+func TestTrieMatchLongestPrefixWithCompletionsBytes(t *testing.T) {
+	trie := createTestTrie()
+	m, r, ok := trie.MatchLongestPrefixWithCompletionsBytes([]byte("abcdefgY"))
+	if !ok || m.PrefixLength != 7 || m.Value != "abcdefg" {
+		t.Errorf("Wrong match %v, %v", m, ok)
+	}
+	if len(r) != len(completions) {
+		t.Fatalf("Wrong completions %q vs. %q", r, completions)
+	}
+	for i, k := range completions {
+		if string(r[i]) != k {
+			t.Errorf("Wrong completion[%d] %s vs. %s", i, r[i], k)
+		}
+	}
+	if m, r, ok := trie.MatchLongestPrefixWithCompletionsBytes([]byte(content)); !ok || m.PrefixLength != 11 || len(r) != 1 || string(r[0]) != "abcdefghijk" {
+		t.Errorf("Wrong match %v, %q, %v", m, r, ok)
+	}
+	if m, r, ok := trie.MatchLongestPrefixWithCompletionsBytes([]byte(noPrefixContent)); ok || r != nil {
+		t.Errorf("Unexpected match %v, %q", m, r)
+	}
+	folded := NewTrieCaseInsensitive()
+	folded.AddString("Go", 1)
+	folded.AddString("Gopher", 2)
+	if _, r, ok := folded.MatchLongestPrefixWithCompletionsBytes([]byte("GOX")); !ok || fmt.Sprintf("%s", r) != "[go gopher]" {
+		t.Errorf("Wrong completions %q, %v", r, ok)
+	}
+}
+
+func TestTrieMatchLongestPrefixMaxLenBytes(t *testing.T) {
+	trie := createTestTrie()
+	for maxLen, expected := range map[int]string{100: "abcdefghijk", 11: "abcdefghijk", 10: "abcdefghi", 8: "abcdefg"} {
+		m, ok := trie.MatchLongestPrefixMaxLenBytes([]byte(content), maxLen)
+		if !ok || m.PrefixLength != len(expected) || m.Value != expected {
+			t.Errorf("Wrong match %v, %v for max length %d, expected %s", m, ok, maxLen, expected)
+		}
+	}
+	for _, maxLen := range []int{6, 0, -1} {
+		if m, ok := trie.MatchLongestPrefixMaxLenBytes([]byte(content), maxLen); ok {
+			t.Errorf("Unexpected match %v for max length %d", m, maxLen)
+		}
+	}
+	trie.AddString("", "empty")
+	if m, ok := trie.MatchLongestPrefixMaxLenBytes([]byte(content), 0); !ok || m.PrefixLength != 0 || m.Value != "empty" {
+		t.Errorf("Wrong match %v, %v for max length 0, expected the empty key", m, ok)
+	}
+}
+
+func TestTrieMatchLongestPrefixMaxLenString(t *testing.T) {
+	trie := createTestTrie()
+	if m, ok := trie.MatchLongestPrefixMaxLenString(content, 9); !ok || m.Value != "abcdefghi" {
+		t.Errorf("Wrong match %v, %v, expected abcdefghi", m, ok)
+	}
+}
+
+func TestTrieLookupOrLongestPrefixBytes(t *testing.T) {
+	routes := NewTrie()
+	routes.Add([]byte("10."), "private")
+	routes.Add([]byte("10.1."), "lab")
+	routes.Add([]byte("10.1.2.3"), "server")
+	routes.Add([]byte("10.1.2.5"), "backup")
+	for key, expected := range map[string]struct {
+		value  string
+		length int
+	}{
+		"10.1.2.3": {"server", 8},
+		"10.1.2.4": {"lab", 5},
+		"10.2.0.1": {"private", 3},
+		"10.1.":    {"lab", 5},
+		// Past the valueless branch node "10.1.2." shared by both servers.
+		"10.1.2.9": {"lab", 5},
+		"10.1.2.":  {"lab", 5},
+	} {
+		v, n, ok := routes.LookupOrLongestPrefixBytes([]byte(key))
+		if !ok || v.(string) != expected.value || n != expected.length {
+			t.Errorf("Wrong result %v, %d, %v for %s, expected %v", v, n, ok, key, expected)
+		}
+	}
+	if v, n, ok := routes.LookupOrLongestPrefixBytes([]byte("192.168.0.1")); ok || v != nil || n != 0 {
+		t.Errorf("Unexpected result %v, %d", v, n)
+	}
+}
+
+func TestTrieLookupOrLongestPrefixString(t *testing.T) {
+	trie := createTestTrie()
+	if v, n, ok := trie.LookupOrLongestPrefixString("abcdefgk"); !ok || n != 8 || v.(string) != "abcdefgk" {
+		t.Errorf("Wrong exact result %v, %d, %v", v, n, ok)
+	}
+	if v, n, ok := trie.LookupOrLongestPrefixString("abcdefgh"); !ok || n != 7 || v.(string) != "abcdefg" {
+		t.Errorf("Wrong fallback result %v, %d, %v", v, n, ok)
+	}
+}
+
+func TestTrieAddString(t *testing.T) {
+	trie := NewTrie()
+	for _, k := range keys {
+		trie.AddString(k, k)
+	}
+	for _, k := range keys {
+		if v, ok := trie.GetBytes([]byte(k)); !ok || v.(string) != k {
+			t.Errorf("Wrong value %v, %v for key %s", v, ok, k)
+		}
+	}
+	trie.Add([]byte("abcdefg"), "bytes")
+	trie.AddString("abcdefg", "string")
+	if v, _ := trie.GetBytes([]byte("abcdefg")); v.(string) != "string" {
+		t.Errorf("Wrong value %v", v)
+	}
+	if trie.Len() != len(keys) {
+		t.Errorf("Wrong length %d, expected %d", trie.Len(), len(keys))
+	}
+}
+
+func TestTrieNilAndEmpty(t *testing.T) {
+	var nilTrie *Trie
+	for name, trie := range map[string]*Trie{"nil": nilTrie, "empty": NewTrie()} {
+		if v, ok := trie.GetString("x"); ok || v != nil {
+			t.Errorf("%s: unexpected GetString result %v, %v", name, v, ok)
+		}
+		if v, ok := trie.GetBytes([]byte("x")); ok || v != nil {
+			t.Errorf("%s: unexpected GetBytes result %v, %v", name, v, ok)
+		}
+		if v, ok := trie.GetAllString("x"); ok || v != nil {
+			t.Errorf("%s: unexpected GetAllString result %v, %v", name, v, ok)
+		}
+		if trie.ContainsString("") || trie.ContainsBytes(nil) {
+			t.Errorf("%s: unexpected key", name)
+		}
+		if trie.HasPrefixString("") || trie.HasPrefixBytes(nil) {
+			t.Errorf("%s: unexpected prefix", name)
+		}
+		if m, ok := trie.MatchShortestPrefixString("x"); ok {
+			t.Errorf("%s: unexpected shortest prefix %v", name, m)
+		}
+		if m, ok := trie.MatchLongestPrefixBytes([]byte("x")); ok {
+			t.Errorf("%s: unexpected longest prefix %v", name, m)
+		}
+		if m, ok := trie.MatchLongestPrefixRunesString("x"); ok {
+			t.Errorf("%s: unexpected longest prefix %v", name, m)
+		}
+		if m, n, err := trie.MatchLongestPrefixReader(strings.NewReader("x")); m.Value != nil || n > 1 || err != nil {
+			t.Errorf("%s: unexpected longest prefix %v, %d, %v", name, m, n, err)
+		}
+		if v, n, ok := trie.LookupOrLongestPrefixString("x"); ok {
+			t.Errorf("%s: unexpected lookup result %v, %d", name, v, n)
+		}
+		if r := trie.MatchAllPrefixesString("x"); len(r) != 0 {
+			t.Errorf("%s: unexpected prefixes %v", name, r)
+		}
+		trie.WalkPrefixesString("x", func(m PrefixMatch) bool {
+			t.Errorf("%s: unexpected prefix %v", name, m)
+			return true
+		})
+		if r := trie.FindAllOccurrencesString("x"); len(r) != 0 {
+			t.Errorf("%s: unexpected occurrences %v", name, r)
+		}
+		if r := trie.ReplaceAllString("x", nil); r != "x" {
+			t.Errorf("%s: unexpected replacement %s", name, r)
+		}
+		if trie.Len() != 0 || len(trie.Values()) != 0 || trie.CountPrefixString("") != 0 {
+			t.Errorf("%s: should be empty", name)
+		}
+		trie.Walk(func(key []byte, value Value) bool {
+			t.Errorf("%s: unexpected key %s", name, key)
+			return true
+		})
+		trie.WalkRange(nil, []byte("z"), func(key []byte, value Value) bool {
+			t.Errorf("%s: unexpected key %s", name, key)
+			return true
+		})
+		if it := trie.Iterator(); it.Next() {
+			t.Errorf("%s: unexpected key %s", name, it.Key())
+		}
+		if r := trie.KeysWithPrefixString(""); len(r) != 0 {
+			t.Errorf("%s: unexpected keys %q", name, r)
+		}
+		if r := trie.MatchWildcardString("?", '?'); len(r) != 0 {
+			t.Errorf("%s: unexpected keys %q", name, r)
+		}
+		if r := trie.FuzzyMatchString("x", 1); len(r) != 0 {
+			t.Errorf("%s: unexpected keys %v", name, r)
+		}
+		if sub, ok := trie.SubTrieString(""); ok {
+			t.Errorf("%s: unexpected sub trie %v", name, sub)
+		}
+		if _, _, ok := trie.MinKey(); ok {
+			t.Errorf("%s: unexpected min key", name)
+		}
+		if _, _, ok := trie.MaxKey(); ok {
+			t.Errorf("%s: unexpected max key", name)
+		}
+		if p := trie.LongestCommonKeyPrefix(); len(p) != 0 {
+			t.Errorf("%s: unexpected common prefix %q", name, p)
+		}
+		if stats := trie.Stats(); stats.LeafCount > 1 || stats.TotalKeyBytes != 0 {
+			t.Errorf("%s: unexpected stats %+v", name, stats)
+		}
+		if !trie.Equal(nilTrie) || !trie.Equal(NewTrie()) {
+			t.Errorf("%s: should equal empty tries", name)
+		}
+		if s := trie.String(); s != `""`+"\n" {
+			t.Errorf("%s: unexpected string %q", name, s)
+		}
+		var dot strings.Builder
+		if err := trie.WriteDOT(&dot); err != nil || strings.Contains(dot.String(), "->") {
+			t.Errorf("%s: unexpected graph %q, %v", name, dot.String(), err)
+		}
+		if data, err := trie.MarshalBinary(); err != nil {
+			t.Errorf("%s: unable to marshal: %v", name, err)
+		} else if restored := createTestTrie(); restored.UnmarshalBinary(data) != nil || restored.Len() != 0 {
+			t.Errorf("%s: unexpected unmarshaled trie %v", name, restored)
+		}
+		if data, err := trie.MarshalJSON(); err != nil || string(data) != "{}" {
+			t.Errorf("%s: unexpected JSON %s, %v", name, data, err)
+		}
+		if with := trie.With([]byte("x"), 1); with.Len() != 1 || trie.Len() != 0 {
+			t.Errorf("%s: unexpected With result %v", name, with)
+		}
+	}
+	if nilTrie.Clone() != nil {
+		t.Errorf("Clone of nil trie should be nil")
+	}
+}
+
+func TestTrieMatchBestPrefixBytes(t *testing.T) {
+	trie := NewTrie()
+	trie.Add([]byte("/"), 1)
+	trie.Add([]byte("/api"), 10)
+	trie.Add([]byte("/api/v1"), 5)
+	byPriority := func(v Value) int { return v.(int) }
+	m, ok := trie.MatchBestPrefixBytes([]byte("/api/v1/users"), byPriority)
+	if !ok || m.PrefixLength != 4 || m.Value.(int) != 10 {
+		t.Errorf("Wrong best prefix %v, %v", m, ok)
+	}
+	if m, ok := trie.MatchBestPrefixBytes([]byte("x"), byPriority); ok {
+		t.Errorf("Unexpected best prefix %v", m)
+	}
+}
+
+func TestTrieMatchBestPrefixString(t *testing.T) {
+	trie := createTestTrie()
+	same := func(v Value) int { return 0 }
+	m, ok := trie.MatchBestPrefixString(content, same)
+	if !ok || m.PrefixLength != 11 {
+		t.Errorf("Longer prefix should win ties, but %v, %v", m, ok)
+	}
+	shortest := func(v Value) int { return -len(v.(string)) }
+	m, ok = trie.MatchBestPrefixString(content, shortest)
+	if !ok || m.PrefixLength != 7 {
+		t.Errorf("Wrong best prefix %v, %v", m, ok)
+	}
+}
+
+func TestTrieMatchAllPrefixKeysBytes(t *testing.T) {
+	trie := createTestTrie()
+	input := []byte(content)
+	r := trie.MatchAllPrefixKeysBytes(input)
+	if len(r) != len(prefixes) {
+		t.Fatalf("Wrong length of prefixes %v vs. %v)", r, prefixes)
+	}
+	for i, p := range prefixes {
+		if r[i].PrefixLength != len(p) || string(r[i].Key) != p || r[i].Value.(string) != p {
+			t.Errorf("Wrong prefix[%d] %v vs. %s", i, r[i], p)
+		}
+		if &r[i].Key[0] != &input[0] {
+			t.Errorf("Key[%d] should share memory with the input", i)
+		}
+	}
+	if r := trie.MatchAllPrefixKeysBytes([]byte(noPrefixContent)); len(r) != 0 {
+		t.Errorf("Unexpected result: %v", r)
+	}
+}
+
+func TestTrieMatchAllPrefixesWithKeysString(t *testing.T) {
+	trie := createTestTrie()
+	r := trie.MatchAllPrefixesWithKeysString(content)
+	if len(r) != len(prefixes) {
+		t.Fatalf("Wrong matches %v, expected %v", r, prefixes)
+	}
+	for i, p := range prefixes {
+		if r[i].Key != p || r[i].Value != p {
+			t.Errorf("Wrong match[%d] %v, expected %s", i, r[i], p)
+		}
+	}
+	if r := trie.MatchAllPrefixesWithKeysString(noPrefixContent); len(r) != 0 {
+		t.Errorf("Unexpected matches %v", r)
+	}
+}
+
+func TestTrieWith(t *testing.T) {
+	trie := createTestTrie()
+	before := trie.String()
+	with := trie.With([]byte("abcdefgh"), "new")
+	if v, ok := with.GetString("abcdefgh"); !ok || v.(string) != "new" {
+		t.Errorf("Wrong value %v, %v in new trie", v, ok)
+	}
+	if trie.ContainsString("abcdefgh") || trie.String() != before {
+		t.Errorf("Original trie should not be modified")
+	}
+	for _, k := range keys {
+		if v, ok := with.GetString(k); !ok || v.(string) != k {
+			t.Errorf("Wrong value %v, %v for key %s in new trie", v, ok, k)
+		}
+	}
+	if with.Len() != len(keys)+1 || trie.Len() != len(keys) {
+		t.Errorf("Wrong lengths %d, %d", with.Len(), trie.Len())
+	}
+	ab, newAb := trie.children['a'], with.children['a']
+	if ab == newAb {
+		t.Errorf("Node on the path should be copied")
+	}
+	if ab.children['X'] != newAb.children['X'] {
+		t.Errorf("Subtree off the path should be shared")
+	}
+	replaced := with.With([]byte(keys[0]), "replaced")
+	if v, _ := with.GetString(keys[0]); v.(string) != keys[0] {
+		t.Errorf("Replacing in a new trie should not modify the old one, but %v", v)
+	}
+	if v, _ := replaced.GetString(keys[0]); v.(string) != "replaced" || replaced.Len() != with.Len() {
+		t.Errorf("Wrong value %v or length %d after replacing", v, replaced.Len())
+	}
+	if v, ok := NewTrie().With(nil, "root").GetString(""); !ok || v.(string) != "root" {
+		t.Errorf("Wrong value %v, %v for empty key", v, ok)
+	}
+	trie.AddString("abe", "base")
+	trie.DeleteString(keys[0])
+	with.DeleteString("abcdf")
+	if with.ContainsString("abe") || !with.ContainsString(keys[0]) || with.Len() != len(keys) {
+		t.Errorf("New trie observed modifications of the original")
+	}
+	if !trie.ContainsString("abcdf") || trie.Len() != len(keys) {
+		t.Errorf("Original trie observed modifications of the new one")
+	}
+	for _, tr := range []*Trie{trie, with, replaced} {
+		if err := tr.Validate(); err != nil {
+			t.Errorf("Invalid trie after modifications: %v", err)
+		}
+	}
+}
+
+func TestTrieSnapshot(t *testing.T) {
+	trie := createTestTrie()
+	snapshot := trie.Snapshot()
+	before := snapshot.String()
+	trie.AddString("abcdefgh", "new")
+	trie.AddString("abcdefghi", "changed")
+	trie.DeleteString("abcdf")
+	trie.DeletePrefixString("abX")
+	trie.Prune(func(key []byte, value Value) bool { return string(key) == "abcdxyz" })
+	if snapshot.String() != before || !snapshot.Equal(createTestTrie()) {
+		t.Errorf("Snapshot observed modifications:\n%s", snapshot)
+	}
+	expected := map[string]Value{
+		"abcdefg":     "abcdefg",
+		"abcdefgh":    "new",
+		"abcdefghi":   "changed",
+		"abcdefghijk": "abcdefghijk",
+		"abcdefgk":    "abcdefgk",
+		"abcdefgXXX":  "abcdefgXXX",
+	}
+	if r := trie.ToMap(); !reflect.DeepEqual(r, expected) {
+		t.Errorf("Wrong keys %v, expected %v", r, expected)
+	}
+	snapshot.AddString("abcdefgk", "snapshot")
+	if v, _ := trie.GetString("abcdefgk"); v != "abcdefgk" {
+		t.Errorf("Modification of the snapshot changed the trie to %v", v)
+	}
+	trie.Restore(snapshot)
+	if v, _ := trie.GetString("abcdefgk"); v != "snapshot" || trie.Len() != len(keys) {
+		t.Errorf("Wrong value %v after Restore", v)
+	}
+	trie.DeleteString("abcdefgk")
+	if !snapshot.ContainsString("abcdefgk") {
+		t.Errorf("Restored trie should not modify the snapshot")
+	}
+	if err := trie.Validate(); err != nil {
+		t.Errorf("Invalid trie: %v", err)
+	}
+}
+
+func TestTrieSnapshotStress(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	trie := NewTrie()
+	expected := map[string]Value{}
+	type frozen struct {
+		snapshot *Trie
+		expected map[string]Value
+	}
+	snapshots := []frozen{}
+	for i := 0; i < 3000; i++ {
+		key := make([]byte, 1+rnd.Intn(8))
+		for j := range key {
+			key[j] = "abc"[rnd.Intn(3)]
+		}
+		switch rnd.Intn(10) {
+		case 0:
+			copied := map[string]Value{}
+			for k, v := range expected {
+				copied[k] = v
+			}
+			snapshots = append(snapshots, frozen{trie.Snapshot(), copied})
+		case 1, 2:
+			trie.Delete(key)
+			delete(expected, string(key))
+		case 3:
+			for k := range expected {
+				if strings.HasPrefix(k, string(key[:1])) {
+					delete(expected, k)
+				}
+			}
+			trie.DeletePrefixBytes(key[:1])
+		default:
+			trie.Add(key, i)
+			expected[string(key)] = i
+		}
+	}
+	if r := trie.ToMap(); !reflect.DeepEqual(r, expected) {
+		t.Errorf("Wrong keys of the trie")
+	}
+	for i, s := range snapshots {
+		if r := s.snapshot.ToMap(); !reflect.DeepEqual(r, s.expected) {
+			t.Errorf("Snapshot %d changed", i)
+		}
+		if err := s.snapshot.Validate(); err != nil {
+			t.Errorf("Invalid snapshot %d: %v", i, err)
+		}
+	}
+}
+
+func TestTrieTryAdd(t *testing.T) {
+	trie := NewBoundedTrie(3)
+	for _, k := range keys[:3] {
+		if !trie.TryAdd([]byte(k), k) {
+			t.Errorf("Unable to add key %s", k)
+		}
+	}
+	if trie.TryAdd([]byte(keys[3]), keys[3]) {
+		t.Errorf("Adding to a full trie should be rejected")
+	}
+	if trie.ContainsString(keys[3]) || trie.HasPrefixString(keys[3]) || trie.Len() != 3 {
+		t.Errorf("Rejected key should not be added")
+	}
+	if !trie.TryAdd([]byte(keys[0]), "overridden") {
+		t.Errorf("Overriding at capacity should succeed")
+	}
+	if v, _ := trie.GetString(keys[0]); v.(string) != "overridden" {
+		t.Errorf("Wrong value %v after override", v)
+	}
+	trie.Delete([]byte(keys[1]))
+	if !trie.TryAdd([]byte(keys[3]), keys[3]) {
+		t.Errorf("Adding after delete should succeed")
+	}
+	for _, maxKeys := range []int{0, -1} {
+		if empty := NewBoundedTrie(maxKeys); empty.TryAdd([]byte(keys[0]), keys[0]) || empty.Len() != 0 {
+			t.Errorf("Adding to a trie bounded to %d keys should be rejected", maxKeys)
+		}
+	}
+	unbounded := NewTrie()
+	for _, k := range keys {
+		if !unbounded.TryAdd([]byte(k), k) {
+			t.Errorf("Unable to add key %s to unbounded trie", k)
+		}
+	}
+}
+
+func TestTrieFromMapToMap(t *testing.T) {
+	m := map[string]Value{"\xff\xfe": "invalid utf-8", "": "root", "nil": nil}
+	for i, k := range keys {
+		m[k] = i
+	}
+	trie := FromMap(m)
+	if trie.Len() != len(m) {
+		t.Errorf("Wrong length %d, expected %d", trie.Len(), len(m))
+	}
+	if v, ok := trie.GetBytes([]byte{0xff, 0xfe}); !ok || v.(string) != "invalid utf-8" {
+		t.Errorf("Wrong value %v, %v for invalid UTF-8 key", v, ok)
+	}
+	r := trie.ToMap()
+	if !reflect.DeepEqual(r, m) {
+		t.Errorf("Wrong map %v, expected %v", r, m)
+	}
+	if !FromMap(r).Equal(trie) {
+		t.Errorf("Round trip should produce an equal trie")
+	}
+	if r := NewTrie().ToMap(); r == nil || len(r) != 0 {
+		t.Errorf("Wrong map %v of empty trie", r)
+	}
+}
+
+func expectPanic(t *testing.T, message string, fn func()) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("Expected panic %q", message)
+		} else if r != message {
+			t.Errorf("Wrong panic %q, expected %q", r, message)
+		}
+	}()
+	fn()
+}
+
+func TestTrieKeyDepthBytes(t *testing.T) {
+	trie := createTestTrie()
+	// root -> ab -> cd -> efg -> hi -> jk
+	//            \      \-> f
+	//             \-> Xdxyz
+	for key, expected := range map[string]int{
+		"abXdxyz":     2,
+		"abcdf":       3,
+		"abcdefg":     3,
+		"abcdefghi":   4,
+		"abcdefghijk": 5,
+	} {
+		if depth, ok := trie.KeyDepthBytes([]byte(key)); !ok || depth != expected {
+			t.Errorf("Wrong depth %d, %v for key %s, expected %d", depth, ok, key, expected)
+		}
+	}
+	for _, key := range nonKeys {
+		if depth, ok := trie.KeyDepthBytes([]byte(key)); ok {
+			t.Errorf("Unexpected depth %d for non-key %s", depth, key)
+		}
+	}
+	trie.AddString("", "empty")
+	if depth, ok := trie.KeyDepthString(""); !ok || depth != 0 {
+		t.Errorf("Wrong depth %d, %v for the empty key", depth, ok)
+	}
+}
+
+func TestTrieGetMany(t *testing.T) {
+	trie := createTestTrie()
+	batch := [][]byte{}
+	for i := range keys {
+		batch = append(batch, []byte(keys[i]), []byte(nonKeys[i%len(nonKeys)]))
+	}
+	values, found := trie.GetMany(batch)
+	if len(values) != len(batch) || len(found) != len(batch) {
+		t.Fatalf("Wrong lengths %d and %d, expected %d", len(values), len(found), len(batch))
+	}
+	for i, k := range batch {
+		v, ok := trie.GetBytes(k)
+		if values[i] != v || found[i] != ok {
+			t.Errorf("Wrong result[%d] %v, %v for key %s, expected %v, %v", i, values[i], found[i], k, v, ok)
+		}
+	}
+	if values, found := trie.GetMany(nil); len(values) != 0 || len(found) != 0 {
+		t.Errorf("Unexpected results %v, %v", values, found)
+	}
+}
+
+func TestTrieMustGet(t *testing.T) {
+	trie := createTestTrie()
+	for _, k := range keys {
+		if v := trie.MustGetString(k); v.(string) != k {
+			t.Errorf("Wrong value %v, expected %v", v, k)
+		}
+		if v := trie.MustGetBytes([]byte(k)); v.(string) != k {
+			t.Errorf("Wrong value %v, expected %v", v, k)
+		}
+	}
+	expectPanic(t, `trie: key "abcd" not found`, func() { trie.MustGetString("abcd") })
+	expectPanic(t, `trie: key "missing" not found`, func() { trie.MustGetBytes([]byte("missing")) })
+}
+
+func TestTrieFilter(t *testing.T) {
+	trie := createTestTrie()
+	long := trie.Filter(func(key []byte, value Value) bool {
+		return len(key) > 8
+	})
+	expected := []string{}
+	for _, k := range keys {
+		if len(k) > 8 {
+			expected = append(expected, k)
+		}
+	}
+	if long.Len() != len(expected) {
+		t.Errorf("Wrong length %d, expected %d", long.Len(), len(expected))
+	}
+	for _, k := range expected {
+		if v, ok := long.GetString(k); !ok || v.(string) != k {
+			t.Errorf("Wrong value %v, %v for key %s", v, ok, k)
+		}
+	}
+	long.Add([]byte("abcdefgh"), "new")
+	if trie.ContainsString("abcdefgh") || trie.Len() != len(keys) {
+		t.Errorf("Filtered trie should be independent of the source")
+	}
+}
+
+func TestTriePrune(t *testing.T) {
+	trie := NewTrie()
+	for i, k := range keys {
+		trie.AddString(k, i)
+	}
+	expired := func(key []byte, value Value) bool {
+		return value.(int)%2 == 0
+	}
+	n := trie.Prune(expired)
+	expected := createTestTrie().Filter(func(key []byte, value Value) bool {
+		for i, k := range keys {
+			if k == string(key) {
+				return i%2 != 0
+			}
+		}
+		return false
+	})
+	if n != len(keys)-expected.Len() || trie.Len() != expected.Len() {
+		t.Errorf("Wrong number %d of pruned keys, length %d", n, trie.Len())
+	}
+	for i, k := range keys {
+		v, ok := trie.GetString(k)
+		if i%2 == 0 && ok {
+			t.Errorf("Pruned key %s still has value %v", k, v)
+		}
+		if i%2 != 0 && (!ok || v.(int) != i) {
+			t.Errorf("Wrong value %v, %v for key %s", v, ok, k)
+		}
+	}
+	// The pruned trie must be as compact as one built from the survivors.
+	if s, e := trie.Stats(), expected.Stats(); s != e {
+		t.Errorf("Wrong structure %+v, expected %+v", s, e)
+	}
+	if n := trie.Prune(expired); n != 0 {
+		t.Errorf("Nothing should be left to prune, but pruned %d", n)
+	}
+	if n := trie.Prune(func(key []byte, value Value) bool { return true }); n != expected.Len() || trie.Len() != 0 || trie.HasPrefixString("") {
+		t.Errorf("Pruning all keys should leave an empty trie, but pruned %d", n)
+	}
+}
+
+func TestTrieSplit(t *testing.T) {
+	trie := createTestTrie()
+	pivot := "abcdefgk"
+	expectedLo, expectedHi := []string{}, []string{}
+	for _, k := range keys {
+		if k < pivot {
+			expectedLo = append(expectedLo, k)
+		} else {
+			expectedHi = append(expectedHi, k)
+		}
+	}
+	sort.Strings(expectedLo)
+	sort.Strings(expectedHi)
+	lo, hi := trie.Split([]byte(pivot))
+	if r := lo.KeysString(); !reflect.DeepEqual(r, expectedLo) {
+		t.Errorf("Wrong lower keys %v, expected %v", r, expectedLo)
+	}
+	if r := hi.KeysString(); !reflect.DeepEqual(r, expectedHi) {
+		t.Errorf("Wrong higher keys %v, expected %v", r, expectedHi)
+	}
+	lo.AddString("new", 1)
+	hi.DeleteString(pivot)
+	if !trie.Equal(createTestTrie()) {
+		t.Errorf("Split tries should be independent of the source")
+	}
+	if lo, hi := NewTrie().Split(nil); lo.Len() != 0 || hi.Len() != 0 {
+		t.Errorf("Splitting an empty trie should give empty tries")
+	}
+}
+
+func TestTrieIntersect(t *testing.T) {
+	trie := createTestTrie()
+	other := NewTrie()
+	for _, k := range []string{"abcdefg", "abcdf", "abcd", "xyz"} {
+		other.AddString(k, len(k))
+	}
+	concat := func(a, b Value) Value {
+		return fmt.Sprint(a, "+", b)
+	}
+	expected := map[string]Value{"abcdefg": "abcdefg+7", "abcdf": "abcdf+5"}
+	if r := trie.Intersect(other, concat).ToMap(); !reflect.DeepEqual(r, expected) {
+		t.Errorf("Wrong intersection %v, expected %v", r, expected)
+	}
+	expected = map[string]Value{"abcdefg": "7+abcdefg", "abcdf": "5+abcdf"}
+	if r := other.Intersect(trie, concat).ToMap(); !reflect.DeepEqual(r, expected) {
+		t.Errorf("Wrong intersection %v, expected %v", r, expected)
+	}
+	if r := trie.Intersect(NewTrie(), concat); r.Len() != 0 {
+		t.Errorf("Intersection with an empty trie should be empty, but got %v", r.ToMap())
+	}
+}
+
+func TestTrieDifference(t *testing.T) {
+	trie := createTestTrie()
+	other := NewTrie()
+	for _, k := range []string{"abcdefg", "abcdefghi", "abcdefghijk", "abcdefgk", "abcd", "xyz"} {
+		other.AddString(k, nil)
+	}
+	expected := map[string]Value{"abcdf": "abcdf", "abcdxyz": "abcdxyz", "abXdxyz": "abXdxyz", "abcdefgXXX": "abcdefgXXX"}
+	if r := trie.Difference(other).ToMap(); !reflect.DeepEqual(r, expected) {
+		t.Errorf("Wrong difference %v, expected %v", r, expected)
+	}
+	if r := trie.Difference(trie); r.Len() != 0 {
+		t.Errorf("Difference with itself should be empty, but got %v", r.ToMap())
+	}
+	if r := trie.Difference(nil); !r.Equal(trie) {
+		t.Errorf("Difference with a nil trie should hold all keys, but got %v", r.ToMap())
+	}
+}
+
+func TestTrieMapValues(t *testing.T) {
+	trie := createTestTrie()
+	lengths := trie.MapValues(func(key []byte, value Value) Value {
+		return len(value.(string))
+	})
+	for _, k := range keys {
+		if v, ok := lengths.GetString(k); !ok || v.(int) != len(k) {
+			t.Errorf("Wrong value %v, %v for key %s", v, ok, k)
+		}
+		if v, _ := trie.GetString(k); v.(string) != k {
+			t.Errorf("Source value %v of key %s should not change", v, k)
+		}
+	}
+	if lengths.Len() != len(keys) || lengths.Stats() != trie.Stats() {
+		t.Errorf("Key structure should be preserved, but %+v", lengths.Stats())
+	}
+}
+
+func TestTrieCheckNoPrefixConflicts(t *testing.T) {
+	trie := createTestTrie()
+	r := trie.CheckNoPrefixConflicts()
+	expected := [][2]string{
+		{"abcdefg", "abcdefgXXX"},
+		{"abcdefg", "abcdefghi"},
+		{"abcdefg", "abcdefghijk"},
+		{"abcdefghi", "abcdefghijk"},
+		{"abcdefg", "abcdefgk"},
+	}
+	if len(r) != len(expected) {
+		t.Fatalf("Wrong conflicts %q, expected %q", r, expected)
+	}
+	for i, c := range expected {
+		if string(r[i].Prefix) != c[0] || string(r[i].Key) != c[1] {
+			t.Errorf("Wrong conflict[%d] %q vs. %q", i, r[i], c)
+		}
+	}
+	phones := NewTrie()
+	for _, k := range []string{"1201", "1202", "44"} {
+		phones.AddString(k, k)
+	}
+	if r := phones.CheckNoPrefixConflicts(); len(r) != 0 {
+		t.Errorf("Unexpected conflicts %q", r)
+	}
+}
+
+func TestTriePrefixChains(t *testing.T) {
+	trie := createTestTrie()
+	expected := [][]string{
+		{"abcdefg", "abcdefgXXX"},
+		{"abcdefg", "abcdefghi", "abcdefghijk"},
+		{"abcdefg", "abcdefgk"},
+	}
+	r := trie.PrefixChains()
+	if len(r) != len(expected) {
+		t.Fatalf("Wrong chains %q, expected %q", r, expected)
+	}
+	for i, chain := range expected {
+		if fmt.Sprintf("%s", r[i]) != fmt.Sprint(chain) {
+			t.Errorf("Wrong chain[%d] %q vs. %q", i, r[i], chain)
+		}
+	}
+	trie.AddString("", "empty")
+	if r := trie.PrefixChains(); len(r) != 6 {
+		t.Errorf("The empty key should start a chain to each of the 6 longest keys, but got %q", r)
+	}
+	if r := NewTrie().PrefixChains(); len(r) != 0 {
+		t.Errorf("Unexpected chains %q", r)
+	}
+}
+
+func TestTrieShortestUniquePrefix(t *testing.T) {
+	trie := createTestTrie()
+	for key, expected := range map[string]string{
+		"abXdxyz":    "abX",
+		"abcdf":      "abcdf",
+		"abcdxyz":    "abcdx",
+		"abcdefgk":   "abcdefgk",
+		"abcdefgXXX": "abcdefgX",
+	} {
+		p, ok := trie.ShortestUniquePrefixString(key)
+		if !ok || p != expected {
+			t.Errorf("Wrong unique prefix %s, %v of %s, expected %s", p, ok, key, expected)
+		}
+		if trie.CountPrefixString(p) != 1 || trie.CountPrefixString(p[:len(p)-1]) == 1 {
+			t.Errorf("Unique prefix %s of %s is not minimal", p, key)
+		}
+	}
+	for _, key := range []string{"abcdefg", "abcdefghi", "abcd", "missing"} {
+		if p, ok := trie.ShortestUniquePrefixBytes([]byte(key)); ok {
+			t.Errorf("Unexpected unique prefix %s of %s", p, key)
+		}
+	}
+	if p, ok := trie.ShortestUniquePrefixBytes([]byte("abcdefghijk")); !ok || string(p) != "abcdefghij" {
+		t.Errorf("Wrong unique prefix %s, %v", p, ok)
+	}
+	single := NewTrie()
+	single.AddString("only", 1)
+	if p, ok := single.ShortestUniquePrefixString("only"); !ok || p != "" {
+		t.Errorf("Wrong unique prefix %q, %v of the only key", p, ok)
+	}
+}
+
+func BenchmarkTrieShortestUniquePrefix(b *testing.B) {
+	keys, values := benchmarkKeys(100000)
+	trie := NewTrie()
+	trie.AddAll(keys, values)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		trie.ShortestUniquePrefixBytes(keys[n%len(keys)])
+	}
+}
+
+func TestTrieWalkDepth(t *testing.T) {
+	trie := createTestTrie()
+	walked := []string{}
+	trie.WalkDepth(3, func(key []byte, value Value, depth int) bool {
+		walked = append(walked, fmt.Sprintf("%s:%d", key, depth))
+		return true
+	})
+	if expected := "abXdxyz:2,abcdefg:3,abcdf:3,abcdxyz:3"; strings.Join(walked, ",") != expected {
+		t.Errorf("Wrong keys %v, expected %s", walked, expected)
+	}
+	count := 0
+	trie.WalkDepth(100, func(key []byte, value Value, depth int) bool {
+		count++
+		return true
+	})
+	if count != len(keys) {
+		t.Errorf("Wrong number of keys %d, expected %d", count, len(keys))
+	}
+	trie.WalkDepth(1, func(key []byte, value Value, depth int) bool {
+		t.Errorf("Unexpected key %s at depth %d", key, depth)
+		return true
+	})
+	count = 0
+	trie.WalkDepth(3, func(key []byte, value Value, depth int) bool {
+		count++
+		return false
+	})
+	if count != 1 {
+		t.Errorf("WalkDepth should stop after 1 key, but visited %d", count)
+	}
+}
+
+func TestTrieIncrement(t *testing.T) {
+	trie := createTestTrie()
+	total := int64(0)
+	for _, delta := range []int64{1, 5, -3, 10, -13} {
+		total += delta
+		if r := trie.Increment([]byte("counter"), delta); r != total {
+			t.Errorf("Wrong total %d, expected %d", r, total)
+		}
+	}
+	if v, ok := trie.GetString("counter"); !ok || v.(int64) != 0 {
+		t.Errorf("Wrong value %v, %v", v, ok)
+	}
+	if trie.Len() != len(keys)+1 {
+		t.Errorf("Wrong length %d, expected %d", trie.Len(), len(keys)+1)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Incrementing a string value should panic")
+		}
+	}()
+	trie.Increment([]byte(keys[0]), 1)
+}
+
+func TestTrieTopKeysWithPrefix(t *testing.T) {
+	trie := createTestTrie()
+	longer := func(a, b Value) bool { return len(a.(string)) > len(b.(string)) }
+	r := trie.TopKeysWithPrefix([]byte("abcdefg"), 2, longer)
+	if len(r) != 2 || string(r[0].Key) != "abcdefghijk" || string(r[1].Key) != "abcdefgXXX" {
+		t.Errorf("Wrong top keys %v", r)
+	}
+	if r[0].PrefixLength != 11 || r[0].Value.(string) != "abcdefghijk" {
+		t.Errorf("Wrong top key %v", r[0])
+	}
+	shorter := func(a, b Value) bool { return len(a.(string)) < len(b.(string)) }
+	r = trie.TopKeysWithPrefix(nil, 3, shorter)
+	if len(r) != 3 || string(r[0].Key) != "abcdf" || string(r[1].Key) != "abXdxyz" || string(r[2].Key) != "abcdefg" {
+		t.Errorf("Wrong top keys %v", r)
+	}
+	if r := trie.TopKeysWithPrefix([]byte("abcdefg"), 10, longer); len(r) != len(completions) {
+		t.Errorf("Wrong number of top keys %d, expected %d", len(r), len(completions))
+	}
+	if r := trie.TopKeysWithPrefix([]byte("x"), 2, longer); len(r) != 0 {
+		t.Errorf("Unexpected top keys %v", r)
+	}
+}
+
+func TestTrieSplitStress(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	trie := NewTrie()
+	expected := map[string]int{}
+	for i := 0; i < 5000; i++ {
+		// A small alphabet and decreasing lengths force many edge splits.
+		key := make([]byte, 1+rnd.Intn(12))
+		for j := range key {
+			key[j] = "abc"[rnd.Intn(3)]
+		}
+		trie.Add(key, i)
+		expected[string(key)] = i
+		if i%7 == 0 {
+			k := string(key[:len(key)/2])
+			if _, ok := trie.DeleteString(k); ok {
+				delete(expected, k)
+			}
+		}
+	}
+	for k, e := range expected {
+		if v, ok := trie.GetString(k); !ok || v.(int) != e {
+			t.Errorf("Wrong value %v, %v for key %s, expected %d", v, ok, k, e)
+		}
+	}
+	if trie.Len() != len(expected) {
+		t.Errorf("Wrong length %d, expected %d", trie.Len(), len(expected))
+	}
+	var check func(node *Trie)
+	check = func(node *Trie) {
+		for b, child := range node.children {
+			if child.prefix[0] != b {
+				t.Errorf("Child %q found under %c", child.prefix, b)
+			}
+			check(child)
+		}
+	}
+	check(trie)
+}
+
+func TestTrieInterning(t *testing.T) {
+	trie := NewInterningTrie()
+	for _, key := range keys {
+		trie.AddString(key, key)
+	}
+	for _, key := range keys {
+		if v, ok := trie.GetString(key); !ok || v != key {
+			t.Errorf("Wrong value %v, %v for key %s", v, ok, key)
+		}
+	}
+	for _, key := range nonKeys {
+		if _, ok := trie.GetString(key); ok {
+			t.Errorf("Unexpected key %s", key)
+		}
+	}
+	if !trie.Equal(createTestTrie()) {
+		t.Errorf("Interning trie differs from the plain one")
+	}
+	shared := NewInterningTrie()
+	for _, key := range []string{"ax", "ay", "bx", "by"} {
+		shared.AddString(key, key)
+	}
+	if &shared.children['a'].children['x'].prefix[0] != &shared.children['b'].children['x'].prefix[0] {
+		t.Errorf("Equal prefixes are not shared")
+	}
+}
+
+func TestTrieInterningCopies(t *testing.T) {
+	trie := NewInterningTrie()
+	for _, key := range keys {
+		trie.AddString(key, key)
+	}
+	clone := trie.Clone()
+	filtered := trie.Filter(func([]byte, Value) bool { return true })
+	// Run with -race: the copies must not share the intern table of the original.
+	var wg sync.WaitGroup
+	for _, tr := range []*Trie{trie, clone, filtered} {
+		wg.Add(1)
+		go func(tr *Trie) {
+			defer wg.Done()
+			for _, key := range randomKeys(100) {
+				tr.Add(key, nil)
+			}
+		}(tr)
+	}
+	wg.Wait()
+	trie.Clear()
+	if len(trie.opts.intern) != 0 {
+		t.Errorf("Clear kept %d interned prefixes", len(trie.opts.intern))
+	}
+	trie.AddString("ax", 1)
+	if len(trie.opts.intern) == 0 {
+		t.Errorf("Cleared trie doesn't intern prefixes")
+	}
+}
+
+func TestTrieInterningDeletes(t *testing.T) {
+	trie := NewInterningTrie()
+	keys := randomKeys(10000)
+	for _, key := range keys {
+		trie.Add(key, nil)
+	}
+	if n := len(trie.opts.intern); trie.MemoryBytes() < trie.Clone().MemoryBytes()+internEntryBytes*n {
+		t.Errorf("MemoryBytes %d should count the table of %d prefixes", trie.MemoryBytes(), n)
+	}
+	for _, key := range keys {
+		trie.Delete(key)
+	}
+	if trie.Len() != 0 || len(trie.opts.intern) > 2 {
+		t.Errorf("Table holds %d prefixes after deleting all %d keys", len(trie.opts.intern), len(keys))
+	}
+	for round := 0; round < 10; round++ {
+		keys := keys[round*1000 : (round+1)*1000]
+		for _, key := range keys {
+			trie.Add(key, nil)
+		}
+		for _, key := range keys[1:] {
+			trie.Delete(key)
+		}
+		if n := len(trie.opts.intern); n > 2*(2*trie.Len()+1) {
+			t.Errorf("Table of %d prefixes for %d keys should have been rebuilt", n, trie.Len())
+		}
+	}
+	trie.AddString("ax", nil)
+	trie.AddString("bx", nil)
+	trie.DeleteString("ax")
+	trie.Compact()
+	if n := len(trie.opts.intern); n != trie.Stats().NodeCount-1 {
+		t.Errorf("Compact kept %d prefixes for %d nodes", n, trie.Stats().NodeCount)
+	}
+	if err := trie.Validate(); err != nil || trie.Len() != 11 {
+		t.Errorf("Invalid trie of %d keys: %v", trie.Len(), err)
+	}
+}
+
+func interningKeys(n int) [][]byte {
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("user%05d/profile", i))
+	}
+	return keys
+}
+
+func benchmarkTrieAddWith(b *testing.B, newTrie func() *Trie) {
+	keys := interningKeys(10000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		trie := newTrie()
+		for i, k := range keys {
+			trie.Add(k, i)
+		}
+	}
+}
+
+func BenchmarkTrieAddPlain(b *testing.B) {
+	benchmarkTrieAddWith(b, NewTrie)
+}
+
+func BenchmarkTrieAddInterning(b *testing.B) {
+	benchmarkTrieAddWith(b, NewInterningTrie)
+}
+
+func TestTrieEmptyKey(t *testing.T) {
+	trie := createTestTrie()
+	if _, ok := trie.GetBytes([]byte{}); ok {
+		t.Errorf("Unexpected value of the empty key")
+	}
+	if _, ok := trie.MatchShortestPrefixString(content); !ok {
+		t.Errorf("Expected a match without the empty key")
+	}
+	trie.Add([]byte{}, "empty")
+	if v, ok := trie.GetBytes([]byte{}); !ok || v != "empty" {
+		t.Errorf("Wrong value %v, %v of the empty key", v, ok)
+	}
+	if v, ok := trie.GetString(""); !ok || v != "empty" {
+		t.Errorf("Wrong value %v, %v of the empty string key", v, ok)
+	}
+	if trie.Len() != len(keys)+1 {
+		t.Errorf("Wrong length %d, expected %d", trie.Len(), len(keys)+1)
+	}
+	for _, in := range []string{"", "b", content} {
+		if m, ok := trie.MatchShortestPrefixString(in); !ok || m.PrefixLength != 0 || m.Value != "empty" {
+			t.Errorf("Wrong shortest match %v, %v for %s", m, ok, in)
+		}
+	}
+	if m, ok := trie.MatchLongestPrefixString("b"); !ok || m.PrefixLength != 0 || m.Value != "empty" {
+		t.Errorf("Wrong longest match %v, %v", m, ok)
+	}
+	if r := trie.MatchAllPrefixesString(content); len(r) != len(prefixes)+1 || r[0].PrefixLength != 0 {
+		t.Errorf("Wrong prefixes %v", r)
+	}
+	if k := trie.KeysString(); k[0] != "" {
+		t.Errorf("Empty key should be first, but got %q", k)
+	}
+	if v, ok := trie.Delete(nil); !ok || v != "empty" {
+		t.Errorf("Wrong deleted value %v, %v", v, ok)
+	}
+	if _, ok := trie.GetString(""); ok || !trie.Equal(createTestTrie()) {
+		t.Errorf("Deleting the empty key should only remove the root value")
+	}
+}
+
+func TestTrieFreeze(t *testing.T) {
+	trie := createTestTrie()
+	trie.Freeze()
+	for _, k := range keys {
+		if v, ok := trie.GetString(k); !ok || v != k {
+			t.Errorf("Wrong value %v, %v for key %s", v, ok, k)
+		}
+	}
+	if r := trie.KeysWithPrefixString("abcdefg"); len(r) != len(completions) {
+		t.Errorf("Wrong keys %q", r)
+	}
+	message := "trie: modification of a frozen Trie"
+	expectPanic(t, message, func() { trie.AddString("new", 1) })
+	expectPanic(t, message, func() { trie.Add([]byte(keys[0]), 1) })
+	expectPanic(t, message, func() { trie.DeleteString(keys[0]) })
+	expectPanic(t, message, func() { trie.Update([]byte("x"), func(Value, bool) Value { return 1 }) })
+	expectPanic(t, message, func() { trie.DeletePrefixString("ab") })
+	expectPanic(t, message, func() { trie.Clear() })
+	expectPanic(t, message, func() { trie.Prune(func([]byte, Value) bool { return true }) })
+	expectPanic(t, message, func() { trie.Merge(createTestTrie(), nil) })
+	if !trie.Equal(createTestTrie()) {
+		t.Errorf("Frozen trie was modified")
+	}
+	parent := createTestTrie()
+	sub, _ := parent.SubTrieString("abcdefg")
+	sub.Freeze()
+	parent.AddString("new", 1)
+	expectPanic(t, message, func() { sub.AddString("x", 1) })
+	for name, copied := range map[string]*Trie{
+		"Clone":    trie.Clone(),
+		"Snapshot": trie.Snapshot(),
+		"Filter":   trie.Filter(func([]byte, Value) bool { return true }),
+		"With":     trie.With([]byte("with"), 1),
+	} {
+		copied.AddString("new", 1)
+		if !copied.ContainsString("new") {
+			t.Errorf("Trie made by %s should not be frozen", name)
+		}
+	}
+	if trie.ContainsString("new") || trie.ContainsString("with") {
+		t.Errorf("Frozen trie observed modifications of its copies")
+	}
+}
@@ -0,0 +1,118 @@
+package trie
+
+// TypedTrie is a Trie whose values are all of type V, so that callers don't need type assertions.
+// It wraps a Trie, which remains the underlying storage, and covers only its basic subset: adding, getting
+// and deleting keys, walking, listing keys with a prefix and matching prefixes.
+type TypedTrie[V any] struct {
+	trie Trie
+}
+
+// TypedPrefixMatch is the type of returned value of TypedTrie's prefix matching functions.
+type TypedPrefixMatch[V any] struct {
+	PrefixLength int
+	Value        V
+}
+
+// NewTypedTrie creates an empty TypedTrie.
+func NewTypedTrie[V any]() *TypedTrie[V] {
+	return &TypedTrie[V]{}
+}
+
+// Add a key value to TypedTrie. Override the value if the same key is given again.
+func (this *TypedTrie[V]) Add(key []byte, value V) {
+	this.trie.Add(key, value)
+}
+
+// Get the value associated with the key. If no such key was added, return the zero value of V, false.
+func (this *TypedTrie[V]) GetBytes(key []byte) (value V, found bool) {
+	v, found := this.trie.GetBytes(key)
+	return typed[V](v), found
+}
+
+// Same as GetBytes but works for string.
+func (this *TypedTrie[V]) GetString(key string) (value V, found bool) {
+	v, found := this.trie.GetString(key)
+	return typed[V](v), found
+}
+
+// Delete the key and return the value associated with it. If no such key was added, return the zero value of V, false.
+func (this *TypedTrie[V]) Delete(key []byte) (old V, existed bool) {
+	v, existed := this.trie.Delete(key)
+	return typed[V](v), existed
+}
+
+// Same as Delete but works for string.
+func (this *TypedTrie[V]) DeleteString(key string) (old V, existed bool) {
+	v, existed := this.trie.DeleteString(key)
+	return typed[V](v), existed
+}
+
+// Len returns the number of keys in the TypedTrie.
+func (this *TypedTrie[V]) Len() int {
+	return this.trie.Len()
+}
+
+// Walk visits every key value in lexicographic order of keys until fn returns false. See Trie.Walk.
+func (this *TypedTrie[V]) Walk(fn func(key []byte, value V) bool) {
+	this.trie.Walk(func(key []byte, value Value) bool {
+		return fn(key, typed[V](value))
+	})
+}
+
+// Match the shortest prefix and associated value. If no prefix is found, return {0, zero value}, false.
+func (this *TypedTrie[V]) MatchShortestPrefixBytes(input []byte) (match TypedPrefixMatch[V], found bool) {
+	return typedMatch[V](this.trie.MatchShortestPrefixBytes(input))
+}
+
+// Same as MatchShortestPrefixBytes but works for string.
+func (this *TypedTrie[V]) MatchShortestPrefixString(input string) (match TypedPrefixMatch[V], found bool) {
+	return typedMatch[V](this.trie.MatchShortestPrefixString(input))
+}
+
+// Match the longest prefix and associated value. If no prefix is found, return {0, zero value}, false.
+func (this *TypedTrie[V]) MatchLongestPrefixBytes(input []byte) (match TypedPrefixMatch[V], found bool) {
+	return typedMatch[V](this.trie.MatchLongestPrefixBytes(input))
+}
+
+// Same as MatchLongestPrefixBytes but works for string.
+func (this *TypedTrie[V]) MatchLongestPrefixString(input string) (match TypedPrefixMatch[V], found bool) {
+	return typedMatch[V](this.trie.MatchLongestPrefixString(input))
+}
+
+// Match all possible prefixes and associated values as a list. If no prefix is found, return an empty list.
+func (this *TypedTrie[V]) MatchAllPrefixesBytes(in []byte) []TypedPrefixMatch[V] {
+	return typedMatches[V](this.trie.MatchAllPrefixesBytes(in))
+}
+
+// Same as MatchAllPrefixesBytes but works for string input.
+func (this *TypedTrie[V]) MatchAllPrefixesString(in string) []TypedPrefixMatch[V] {
+	return typedMatches[V](this.trie.MatchAllPrefixesString(in))
+}
+
+// Return all keys starting with the prefix in lexicographic order. If no key has the prefix, return an empty list.
+func (this *TypedTrie[V]) KeysWithPrefixBytes(prefix []byte) [][]byte {
+	return this.trie.KeysWithPrefixBytes(prefix)
+}
+
+// Same as KeysWithPrefixBytes but works for string.
+func (this *TypedTrie[V]) KeysWithPrefixString(prefix string) [][]byte {
+	return this.trie.KeysWithPrefixString(prefix)
+}
+
+// typed converts a stored Value back to V. A nil Value becomes the zero value of V.
+func typed[V any](v Value) V {
+	t, _ := v.(V)
+	return t
+}
+
+func typedMatch[V any](match PrefixMatch, found bool) (TypedPrefixMatch[V], bool) {
+	return TypedPrefixMatch[V]{match.PrefixLength, typed[V](match.Value)}, found
+}
+
+func typedMatches[V any](matches []PrefixMatch) []TypedPrefixMatch[V] {
+	result := make([]TypedPrefixMatch[V], len(matches))
+	for i, m := range matches {
+		result[i] = TypedPrefixMatch[V]{m.PrefixLength, typed[V](m.Value)}
+	}
+	return result
+}
@@ -0,0 +1,69 @@
+package trie
+
+import (
+	"testing"
+)
+
+func TestTypedTrieString(t *testing.T) {
+	trie := NewTypedTrie[string]()
+	for _, k := range keys {
+		trie.Add([]byte(k), k)
+	}
+	for _, k := range keys {
+		v, ok := trie.GetString(k)
+		if !ok {
+			t.Errorf("Unable to find key %s", k)
+		}
+		if v != k {
+			t.Errorf("Wrong value %v, expected %v", v, k)
+		}
+	}
+	for _, k := range nonKeys {
+		v, ok := trie.GetBytes([]byte(k))
+		if ok || v != "" {
+			t.Errorf("Unexpected key %s, value %v", k, v)
+		}
+	}
+	r := trie.MatchAllPrefixesString(content)
+	if len(r) != len(prefixes) {
+		t.Fatalf("Wrong length of prefixes %v vs. %v)", r, prefixes)
+	}
+	for i, p := range prefixes {
+		if r[i].Value != p || content[:r[i].PrefixLength] != p {
+			t.Errorf("Wrong prefix[%d] %v vs. %s", i, r[i], p)
+		}
+	}
+	m, ok := trie.MatchLongestPrefixBytes([]byte(content))
+	if expected := prefixes[len(prefixes)-1]; !ok || m.Value != expected {
+		t.Errorf("Wrong longest prefix %v, expected %s", m, expected)
+	}
+	if r := trie.KeysWithPrefixString("abcdefg"); len(r) != len(completions) {
+		t.Errorf("Wrong keys with prefix %q, expected %q", r, completions)
+	}
+}
+
+func TestTypedTrieInt(t *testing.T) {
+	trie := NewTypedTrie[int]()
+	for i, k := range keys {
+		trie.Add([]byte(k), i)
+	}
+	sum := 0
+	for _, k := range keys {
+		v, _ := trie.GetString(k)
+		sum += v
+	}
+	if expected := len(keys) * (len(keys) - 1) / 2; sum != expected {
+		t.Errorf("Wrong sum of values %d, expected %d", sum, expected)
+	}
+	v, ok := trie.DeleteString(keys[1])
+	if !ok || v != 1 {
+		t.Errorf("Wrong deleted value %v, %v", v, ok)
+	}
+	if trie.Len() != len(keys)-1 {
+		t.Errorf("Wrong length %d, expected %d", trie.Len(), len(keys)-1)
+	}
+	m, ok := trie.MatchShortestPrefixString(content)
+	if !ok || m.Value != 0 {
+		t.Errorf("Wrong shortest prefix %v", m)
+	}
+}